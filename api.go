@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Limits of the lists returned by the JSON API.
+const (
+	defaultResultLimit   = 100
+	maxResultLimit       = 1000
+	defaultIncidentLimit = 50
+	maxIncidentLimit     = 500
+)
+
+// Registers the versioned JSON API. The documents are defined in pkg/models.
+func handleAPI(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/targets", apiTargets)
+	mux.HandleFunc("/api/v1/targets/", apiTarget)
+	mux.HandleFunc("/api/v1/incidents", apiIncidents)
+}
+
+// Helper function that writes v as the JSON response.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("api: failed to write response: %v", err)
+	}
+}
+
+// Helper function that writes an error as {"error": msg}.
+func apiError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+// Helper function that parses the limit query parameter.
+func queryLimit(r *http.Request, def int, max int) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return def, true
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 {
+		return 0, false
+	}
+	if limit > max {
+		limit = max
+	}
+	return limit, true
+}
+
+// Helper function that parses a time query parameter.
+func queryTime(r *http.Request, param string, def time.Time) (time.Time, bool) {
+	v := r.URL.Query().Get(param)
+	if v == "" {
+		return def, true
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	return t, err == nil
+}
+
+// GET /api/v1/targets
+func apiTargets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	out := models.TargetsV1{Targets: []models.TargetV1{}}
+	for _, target := range results.Targets() {
+		v := models.TargetV1{
+			Name:               target.Name,
+			Type:               target.Type,
+			Address:            target.Address,
+			IntervalSeconds:    target.Interval,
+			TimeoutSeconds:     target.Timeout,
+			HealthyThreshold:   target.HThreshold,
+			UnhealthyThreshold: target.UhThreshold,
+		}
+		if target.SLO.Enabled() {
+			slo := target.SLO
+			v.SLO = &slo
+		}
+		if panel, ok := results.Target(target.Name); ok {
+			v.State = panel.StatusLogs.State
+		}
+		out.Targets = append(out.Targets, v)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// GET /api/v1/targets/{name}/status and /api/v1/targets/{name}/results
+func apiTarget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/targets/"), "/")
+	if len(parts) != 2 {
+		apiError(w, http.StatusNotFound, "not found")
+		return
+	}
+	panel, ok := results.Target(parts[0])
+	if !ok {
+		apiError(w, http.StatusNotFound, "unknown target "+strconv.Quote(parts[0]))
+		return
+	}
+	switch parts[1] {
+	case "status":
+		writeJSON(w, http.StatusOK, statusV1(panel))
+	case "results":
+		apiResults(w, r, panel.Name)
+	default:
+		apiError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// Helper function that builds the status document of a target from its panel.
+func statusV1(panel models.TargetLogWarehouse) models.StatusV1 {
+	v := models.StatusV1{
+		Target:        panel.Name,
+		State:         panel.StatusLogs.State,
+		UptimeCount:   panel.StatusLogs.Uptime,
+		DowntimeCount: panel.StatusLogs.Downtime,
+		ChangedAt:     panel.StatusLogs.Timestamp,
+	}
+	if !panel.ClientLogs.Start.IsZero() {
+		last := panel.ClientLogs.V1()
+		v.LastResult = &last
+	}
+	if len(panel.Incidents) > 0 && panel.Incidents[0].IsOpen() {
+		inc := panel.Incidents[0].V1()
+		v.OpenIncident = &inc
+	}
+	if report := panel.SLO; report != nil {
+		slo := &models.SLOV1{
+			Objective:       report.SLO.Objective,
+			WindowDays:      report.SLO.WindowDays,
+			Availability:    map[string]float64{},
+			BudgetRemaining: report.BudgetRemaining,
+			Alerts:          []string{},
+			ComputedAt:      report.At,
+		}
+		for _, w := range report.Windows {
+			slo.Availability[strconv.Itoa(int(w.Window/(24*time.Hour)))+"d"] = w.Availability
+		}
+		for _, b := range report.Burn {
+			if b.Firing {
+				slo.Alerts = append(slo.Alerts, b.Alert)
+			}
+		}
+		v.SLO = slo
+	}
+	return v
+}
+
+// Probe history of a target, oldest first: the results that started at since
+// or later or, without since, the most recent ones. Either way they stop at
+// before, now by default. Both are RFC 3339 times, so paging back is a matter
+// of passing the start of the first result as before.
+func apiResults(w http.ResponseWriter, r *http.Request, name string) {
+	since, ok := queryTime(r, "since", time.Time{})
+	if !ok {
+		apiError(w, http.StatusBadRequest, "since must be an RFC 3339 time")
+		return
+	}
+	before, ok := queryTime(r, "before", time.Now())
+	if !ok {
+		apiError(w, http.StatusBadRequest, "before must be an RFC 3339 time")
+		return
+	}
+	limit, ok := queryLimit(r, defaultResultLimit, maxResultLimit)
+	if !ok {
+		apiError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+	if db == nil {
+		apiError(w, http.StatusServiceUnavailable, "storage unavailable")
+		return
+	}
+
+	var history []models.Result
+	var err error
+	if since.IsZero() {
+		history, err = db.LatestResults(r.Context(), name, before, limit)
+	} else {
+		history, err = db.Results(r.Context(), name, since, before, limit)
+	}
+	if err != nil {
+		log.Printf("%s: api: failed to read results: %v", name, err)
+		apiError(w, http.StatusInternalServerError, "failed to read results")
+		return
+	}
+	out := models.ResultsV1{Results: make([]models.ResultV1, 0, len(history))}
+	for _, res := range history {
+		out.Results = append(out.Results, res.V1())
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// GET /api/v1/incidents?target=&limit=, newest first.
+func apiIncidents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := r.URL.Query().Get("target")
+	if _, ok := results.Target(name); name != "" && !ok {
+		apiError(w, http.StatusNotFound, "unknown target "+strconv.Quote(name))
+		return
+	}
+	limit, ok := queryLimit(r, defaultIncidentLimit, maxIncidentLimit)
+	if !ok {
+		apiError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+	if db == nil {
+		apiError(w, http.StatusServiceUnavailable, "storage unavailable")
+		return
+	}
+
+	incs, err := db.Incidents(r.Context(), name, limit)
+	if err != nil {
+		log.Printf("api: failed to read incidents: %v", err)
+		apiError(w, http.StatusInternalServerError, "failed to read incidents")
+		return
+	}
+	out := models.IncidentsV1{Incidents: make([]models.IncidentV1, 0, len(incs))}
+	for _, inc := range incs {
+		out.Incidents = append(out.Incidents, inc.V1())
+	}
+	writeJSON(w, http.StatusOK, out)
+}
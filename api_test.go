@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/core"
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Helper function that sets up two targets with some history in a memory store.
+func apiFixture(t *testing.T) time.Time {
+	ctx := context.Background()
+	store := core.NewMemoryStore()
+	db = store
+	results = core.NewResults(0, "")
+	t.Cleanup(func() { db = nil })
+
+	now := time.Now().Truncate(time.Second)
+	results.Add(models.Target{Name: "tonto", Type: "tcp", Address: "tonto.cloudwalk.io:3000", Interval: 2, Timeout: 30, HThreshold: 3, UhThreshold: 3,
+		SLO: models.SLO{Objective: 99.9, WindowDays: 30}})
+	results.Add(models.Target{Name: "web", Type: "http", Address: "https://tonto-http.cloudwalk.io"})
+	for i := 0; i < 5; i++ {
+		res := models.Result{Target: "tonto", Type: "tcp", Start: now.Add(time.Duration(i-5) * time.Minute), Duration: 1500 * time.Microsecond, Success: i != 3}
+		if !res.Success {
+			res.ErrorClass = models.ErrReadTimeout
+		}
+		if err := store.AddResult(ctx, res); err != nil {
+			t.Fatal(err)
+		}
+		results.Record(res, "")
+	}
+	results.SetStatus("tonto", models.Status{State: models.StateHealthy, Uptime: 1, Timestamp: now})
+
+	incs := []models.Incident{
+		{ID: "tonto-1", Target: "tonto", Opened: now.Add(-2 * time.Hour), Closed: now.Add(-time.Hour), Duration: time.Hour, Reason: models.ErrDNS, Total: 10, Failures: 9},
+		{ID: "web-1", Target: "web", Opened: now.Add(-time.Minute), Reason: models.ErrTLS, Probes: []models.Result{{Target: "web", ErrorClass: models.ErrTLS}}},
+	}
+	for _, inc := range incs {
+		if err := store.SaveIncident(ctx, inc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results.SetIncidents("web", []models.Incident{incs[1]})
+	results.SetSLO("tonto", models.SLOReport{Target: "tonto", SLO: models.SLO{Objective: 99.9, WindowDays: 30}, At: now,
+		Windows:         []models.SLOWindow{{Window: 24 * time.Hour, Availability: 80}},
+		BudgetRemaining: -199,
+		Burn:            []models.BurnRate{{Alert: "fast", Firing: true}, {Alert: "slow"}}})
+	return now
+}
+
+// Helper function that serves a GET request to the API and decodes the response into v.
+func apiGet(t *testing.T, url string, v interface{}) int {
+	mux := http.NewServeMux()
+	handleAPI(mux)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected content type: got (%v) want (%v)", ct, "application/json")
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s: %v: %s", url, err, rr.Body)
+	}
+	return rr.Code
+}
+
+func TestAPITargets(t *testing.T) {
+	apiFixture(t)
+	var got models.TargetsV1
+	if code := apiGet(t, "/api/v1/targets", &got); code != http.StatusOK {
+		t.Fatalf("unexpected status: got (%v) want (%v)", code, http.StatusOK)
+	}
+	if len(got.Targets) != 2 {
+		t.Fatalf("unexpected targets: %+v", got.Targets)
+	}
+	tonto, web := got.Targets[0], got.Targets[1]
+	if tonto.Name != "tonto" || tonto.Type != "tcp" || tonto.IntervalSeconds != 2 || tonto.State != models.StateHealthy ||
+		tonto.SLO == nil || tonto.SLO.Objective != 99.9 {
+		t.Errorf("unexpected target: %+v", tonto)
+	}
+	if web.Name != "web" || web.SLO != nil {
+		t.Errorf("unexpected target: %+v", web)
+	}
+}
+
+func TestAPIStatus(t *testing.T) {
+	now := apiFixture(t)
+
+	var tonto models.StatusV1
+	if code := apiGet(t, "/api/v1/targets/tonto/status", &tonto); code != http.StatusOK {
+		t.Fatalf("unexpected status: got (%v) want (%v)", code, http.StatusOK)
+	}
+	if tonto.State != models.StateHealthy || tonto.UptimeCount != 1 || !tonto.ChangedAt.Equal(now) || tonto.OpenIncident != nil {
+		t.Errorf("unexpected status: %+v", tonto)
+	}
+	if tonto.LastResult == nil || tonto.LastResult.DurationMs != 1.5 || !tonto.LastResult.Success {
+		t.Errorf("unexpected last result: %+v", tonto.LastResult)
+	}
+	if tonto.SLO == nil || tonto.SLO.Availability["1d"] != 80 || len(tonto.SLO.Alerts) != 1 || tonto.SLO.Alerts[0] != "fast" {
+		t.Errorf("unexpected slo: %+v", tonto.SLO)
+	}
+
+	var web models.StatusV1
+	apiGet(t, "/api/v1/targets/web/status", &web)
+	if web.LastResult != nil || web.SLO != nil || web.OpenIncident == nil || web.OpenIncident.Closed != nil || web.OpenIncident.Reason != "tls_error" {
+		t.Errorf("unexpected status: %+v", web)
+	}
+}
+
+func TestAPIResults(t *testing.T) {
+	now := apiFixture(t)
+	tests := []struct {
+		url   string
+		code  int
+		count int
+	}{
+		{"/api/v1/targets/tonto/results", http.StatusOK, 5},
+		{"/api/v1/targets/tonto/results?limit=2", http.StatusOK, 2},
+		{"/api/v1/targets/tonto/results?since=" + now.Add(-150*time.Second).Format(time.RFC3339), http.StatusOK, 2},
+		{"/api/v1/targets/web/results", http.StatusOK, 0},
+		{"/api/v1/targets/tonto/results?limit=0", http.StatusBadRequest, 0},
+		{"/api/v1/targets/tonto/results?since=yesterday", http.StatusBadRequest, 0},
+		{"/api/v1/targets/tonto/results?before=tomorrow", http.StatusBadRequest, 0},
+		{"/api/v1/targets/missing/results", http.StatusNotFound, 0},
+		{"/api/v1/targets/tonto/other", http.StatusNotFound, 0},
+	}
+	for _, tt := range tests {
+		var got struct {
+			models.ResultsV1
+			Error string `json:"error"`
+		}
+		code := apiGet(t, tt.url, &got)
+		if code != tt.code {
+			t.Errorf("%s: unexpected status: got (%v) want (%v)", tt.url, code, tt.code)
+		}
+		if code == http.StatusOK && (got.Results == nil || len(got.Results) != tt.count) {
+			t.Errorf("%s: unexpected results: got (%v) want (%v)", tt.url, len(got.Results), tt.count)
+		}
+		if code != http.StatusOK && got.Error == "" {
+			t.Errorf("%s: missing error message", tt.url)
+		}
+	}
+
+	var got models.ResultsV1
+	apiGet(t, "/api/v1/targets/tonto/results", &got)
+	for i := 1; i < len(got.Results); i++ {
+		if got.Results[i].Start.Before(got.Results[i-1].Start) {
+			t.Errorf("results are not oldest first: %+v", got.Results)
+		}
+	}
+	if len(got.Results) == 5 && (got.Results[3].Success || got.Results[3].ErrorClass != "read_timeout") {
+		t.Errorf("unexpected result: %+v", got.Results[3])
+	}
+}
+
+// More results than the limit: the newest are returned unless since is given.
+func TestAPIResultsLimit(t *testing.T) {
+	now := apiFixture(t)
+	ago := func(m int) time.Time { return now.Add(time.Duration(-m) * time.Minute) }
+	tests := []struct {
+		url  string
+		want []time.Time
+	}{
+		{"/api/v1/targets/tonto/results?limit=2", []time.Time{ago(2), ago(1)}},
+		{"/api/v1/targets/tonto/results?limit=2&before=" + ago(2).Format(time.RFC3339), []time.Time{ago(4), ago(3)}},
+		{"/api/v1/targets/tonto/results?limit=2&since=" + ago(60).Format(time.RFC3339), []time.Time{ago(5), ago(4)}},
+		{"/api/v1/targets/tonto/results?limit=2&since=" + ago(60).Format(time.RFC3339) + "&before=" + ago(4).Format(time.RFC3339), []time.Time{ago(5)}},
+	}
+	for _, tt := range tests {
+		var got models.ResultsV1
+		if code := apiGet(t, tt.url, &got); code != http.StatusOK {
+			t.Fatalf("%s: unexpected status: got (%v) want (%v)", tt.url, code, http.StatusOK)
+		}
+		var starts []time.Time
+		for _, res := range got.Results {
+			starts = append(starts, res.Start)
+		}
+		if fmt.Sprint(starts) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: unexpected results: got (%v) want (%v)", tt.url, starts, tt.want)
+		}
+	}
+}
+
+func TestAPIIncidents(t *testing.T) {
+	apiFixture(t)
+	tests := []struct {
+		url  string
+		code int
+		ids  []string
+	}{
+		{"/api/v1/incidents", http.StatusOK, []string{"web-1", "tonto-1"}},
+		{"/api/v1/incidents?limit=1", http.StatusOK, []string{"web-1"}},
+		{"/api/v1/incidents?target=tonto", http.StatusOK, []string{"tonto-1"}},
+		{"/api/v1/incidents?target=missing", http.StatusNotFound, nil},
+		{"/api/v1/incidents?limit=x", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		var got models.IncidentsV1
+		if code := apiGet(t, tt.url, &got); code != tt.code {
+			t.Errorf("%s: unexpected status: got (%v) want (%v)", tt.url, code, tt.code)
+		}
+		var ids []string
+		for _, inc := range got.Incidents {
+			ids = append(ids, inc.ID)
+		}
+		if len(ids) != len(tt.ids) {
+			t.Errorf("%s: unexpected incidents: got (%v) want (%v)", tt.url, ids, tt.ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != tt.ids[i] {
+				t.Errorf("%s: unexpected incidents: got (%v) want (%v)", tt.url, ids, tt.ids)
+			}
+		}
+	}
+
+	var got models.IncidentsV1
+	apiGet(t, "/api/v1/incidents?target=tonto", &got)
+	if inc := got.Incidents[0]; inc.Open || inc.Closed == nil || inc.DurationMs != 3600000 || inc.FailedProbes != 9 || inc.Probes == nil {
+		t.Errorf("unexpected incident: %+v", inc)
+	}
+}
+
+func TestAPIMethodNotAllowed(t *testing.T) {
+	apiFixture(t)
+	mux := http.NewServeMux()
+	handleAPI(mux)
+	for _, url := range []string{"/api/v1/targets", "/api/v1/targets/tonto/status", "/api/v1/incidents"} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("POST", url, nil))
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s: unexpected status: got (%v) want (%v)", url, rr.Code, http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/icommit/SRETest/core"
+)
+
+// checkConfig validates the configuration file given in args, or the one the
+// monitor would read, with the environment and flags applied, and prints every
+// problem found to w. It returns the exit code of the check-config command: 0
+// when the configuration is valid, 1 when it is not and 2 on a usage error.
+func checkConfig(args []string, w io.Writer) int {
+	path := configFile()
+	switch len(args) {
+	case 0:
+	case 1:
+		path = args[0]
+	default:
+		fmt.Fprintln(w, "usage: check-config [file]")
+		return 2
+	}
+	C, err := loadConfig(path)
+	if problems, ok := err.(core.Problems); ok {
+		for _, p := range problems {
+			fmt.Fprintln(w, p)
+		}
+		fmt.Fprintf(w, "%s: %d problem(s) found\n", path, len(problems))
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return 1
+	}
+	fmt.Fprintf(w, "%s: ok, %d target(s)\n", path, len(core.Targets(C)))
+	return 0
+}
+
+// dumpConfig prints the configuration the monitor would run with to w, every
+// layer applied and secrets redacted. It returns the exit code of the config
+// dump command.
+func dumpConfig(w io.Writer) int {
+	C, err := loadConfig(configFile())
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return 1
+	}
+	buf, err := core.Dump(C)
+	if err != nil {
+		fmt.Fprintln(w, err)
+		return 1
+	}
+	w.Write(buf)
+	return 0
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/icommit/SRETest/core"
+)
+
+func TestCheckConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	writeConfig(t, path, `  - name: tonto
+    type: tcp
+    address: "tonto.cloudwalk.io:3000"
+`)
+	var out bytes.Buffer
+	if code := checkConfig([]string{path}, &out); code != 0 {
+		t.Errorf("unexpected exit code: got (%d) want (0): %s", code, out.String())
+	}
+
+	writeConfig(t, path, `  - name: tonto
+    type: tcp
+    address: "tonto.cloudwalk.io"
+    interval: 0
+`)
+	out.Reset()
+	if code := checkConfig([]string{path}, &out); code != 1 {
+		t.Errorf("unexpected exit code: got (%d) want (1)", code)
+	}
+	for _, want := range []string{
+		path + `:13: targets[0].address: "tonto.cloudwalk.io" is not a host:port address`,
+		path + `:14: targets[0].interval: 0 is not at least 1`,
+		"2 problem(s) found",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in output: %s", want, out.String())
+		}
+	}
+
+	// without a file, the one of CONFIG_FILE is checked.
+	t.Setenv("CONFIG_FILE", path)
+	if code := checkConfig(nil, &out); code != 1 {
+		t.Errorf("unexpected exit code: got (%d) want (1)", code)
+	}
+	if code := checkConfig([]string{"a", "b"}, &out); code != 2 {
+		t.Errorf("unexpected exit code: got (%d) want (2)", code)
+	}
+}
+
+func TestDumpConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	writeConfig(t, path, `  - name: tonto
+    type: tcp
+    address: "tonto.cloudwalk.io:3000"
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("MONITOR_TIMEOUT", "4")
+	flagLayer = core.Layer{"timeout": {Value: "6", Source: "flag -timeout"}}
+	defer func() { flagLayer = nil }()
+
+	var out bytes.Buffer
+	if code := dumpConfig(&out); code != 0 {
+		t.Fatalf("unexpected exit code: got (%d) want (0): %s", code, out.String())
+	}
+	for _, want := range []string{"  auth_token: " + core.Redacted + "\n", "  timeout: 6\n", "  message: hello\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in dump:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "secret") {
+		t.Errorf("secret in dump:\n%s", out.String())
+	}
+}
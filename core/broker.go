@@ -0,0 +1,88 @@
+package core
+
+import (
+	"sync"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Events buffered per subscriber unless configured otherwise.
+const DefaultEventBuffer = 64
+
+// Broker fans events out to any number of subscribers, such as the browsers
+// connected to the live feed. Publish never blocks: every subscriber has a
+// bounded buffer, and a subscriber that lets it fill up is dropped instead of
+// holding up the probe loops. Its channel is closed so it can reconnect and
+// start over from a fresh page.
+type Broker struct {
+	mu     sync.Mutex
+	subs   map[chan models.Event]struct{}
+	buffer int
+	closed bool
+}
+
+// NewBroker returns a broker buffering up to buffer events per subscriber, or
+// DefaultEventBuffer if buffer is not positive.
+func NewBroker(buffer int) *Broker {
+	if buffer <= 0 {
+		buffer = DefaultEventBuffer
+	}
+	return &Broker{subs: make(map[chan models.Event]struct{}), buffer: buffer}
+}
+
+// Subscribe returns a channel receiving every event published from now on and
+// a function to unsubscribe. The channel is closed on unsubscribe, or when
+// the subscriber falls too far behind.
+func (b *Broker) Subscribe() (<-chan models.Event, func()) {
+	ch := make(chan models.Event, b.buffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subs[ch] = struct{}{}
+	return ch, func() { b.drop(ch) }
+}
+
+// Publish sends ev to every subscriber.
+func (b *Broker) Publish(ev models.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close closes the channel of every subscriber, ending their streams, and of
+// every later one. Events published afterwards go nowhere.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Subscribers returns the number of subscribers.
+func (b *Broker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+func (b *Broker) drop(ch chan models.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+func TestBroker(t *testing.T) {
+	b := NewBroker(2)
+	fast, unsubscribe := b.Subscribe()
+	defer unsubscribe()
+	slow, _ := b.Subscribe()
+
+	for i := 0; i < 3; i++ {
+		b.Publish(models.Event{Type: "result", Seq: uint64(i)})
+		<-fast
+	}
+	// the slow subscriber got the first two events and was dropped on the third.
+	var seqs []uint64
+	for ev := range slow {
+		seqs = append(seqs, ev.Seq)
+	}
+	if len(seqs) != 2 || seqs[0] != 0 || seqs[1] != 1 {
+		t.Errorf("unexpected events: got (%v) want (%v)", seqs, []uint64{0, 1})
+	}
+	if n := b.Subscribers(); n != 1 {
+		t.Errorf("unexpected subscribers: got (%v) want (%v)", n, 1)
+	}
+
+	unsubscribe()
+	if _, ok := <-fast; ok {
+		t.Errorf("channel is still open after unsubscribe")
+	}
+	unsubscribe() // twice is fine
+	b.Publish(models.Event{Type: "result"})
+	if n := b.Subscribers(); n != 0 {
+		t.Errorf("unexpected subscribers: got (%v) want (%v)", n, 0)
+	}
+}
+
+func TestBrokerClose(t *testing.T) {
+	b := NewBroker(2)
+	ch, unsubscribe := b.Subscribe()
+	b.Close()
+	if _, ok := <-ch; ok {
+		t.Errorf("channel is still open after close")
+	}
+	unsubscribe()
+	late, _ := b.Subscribe()
+	if _, ok := <-late; ok {
+		t.Errorf("channel subscribed after close is open")
+	}
+	b.Publish(models.Event{Type: "result"})
+	if n := b.Subscribers(); n != 0 {
+		t.Errorf("unexpected subscribers: got (%v) want (%v)", n, 0)
+	}
+}
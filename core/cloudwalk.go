@@ -1,7 +1,7 @@
 /*
 Package core is the workhorse for this project. The package define internal and
-exportable functions for our main program. The functions to create a firestore client
-send email notification message, make connection with tcp and http echo servers and return their state
+exportable functions for our main program. The storage backends for status data
+(firestore, in memory or an embedded bolt file), the functions to send email notification message, make connection with tcp and http echo servers and return their state
 and a wrapper function for the return http and tcp state an their logs is defined here.
 */
 package core
@@ -9,24 +9,58 @@ package core
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"cloud.google.com/go/firestore"
 	"github.com/icommit/SRETest/pkg/models"
 	"github.com/mailgun/mailgun-go/v4"
-	"gopkg.in/yaml.v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var msg_tcp string  //failure/success message tcp
-var msg_http string //failure/success message http
+// Failure/success threshold message of the last check for each target.
+var thresholds = struct {
+	sync.Mutex
+	msg map[string]string
+}{msg: make(map[string]string)}
+
+// Mailgun settings notifications are sent with. They are kept up to date with
+// the configuration by SetMail, so credentials never need to be read again.
+var mailer struct {
+	sync.RWMutex
+	domain, apiKey, sender string
+}
+
+// SetMail makes notifications use the mail settings of c.
+func SetMail(c *models.Config) {
+	mailer.Lock()
+	defer mailer.Unlock()
+	mailer.domain, mailer.apiKey, mailer.sender = c.Handlers.Domain, c.Handlers.APIKey, c.Handlers.Sender
+}
+
+// ThresholdMessage returns the message produced by the last health check of
+// the named target, or an empty string if no threshold was reached.
+func ThresholdMessage(name string) string {
+	thresholds.Lock()
+	defer thresholds.Unlock()
+	return thresholds.msg[name]
+}
+
+func setThreshold(name string, msg string) {
+	thresholds.Lock()
+	defer thresholds.Unlock()
+	thresholds.msg[name] = msg
+}
 
 // Helper function properly get items from path.
 func Currentdir() (cwd string) {
@@ -38,24 +72,8 @@ func Currentdir() (cwd string) {
 	return cwd
 }
 
-// Helper function to read our yaml file and parse its fields to Config Struct
-func ReadConf(f string) (*models.Config, error) {
-	buf, err := ioutil.ReadFile(f)
-	if err != nil {
-		return nil, err
-	}
-
-	c := &models.Config{}
-	err = yaml.Unmarshal(buf, c)
-	if err != nil {
-		return nil, fmt.Errorf("in file %q: %v", f, err)
-	}
-
-	return c, nil
-}
-
 // Helper function to send email using Mailgun.
-func sendMail(ctx context.Context, domain string, privateKey string, sender string, recipient string, subject string, body string) {
+func sendMail(ctx context.Context, domain string, privateKey string, sender string, recipient string, subject string, body string) error {
 	mg := mailgun.NewMailgun(domain, privateKey)
 	message := mg.NewMessage(sender, subject, body, recipient)
 	rtx, cancel := context.WithTimeout(ctx, time.Second*10)
@@ -63,307 +81,280 @@ func sendMail(ctx context.Context, domain string, privateKey string, sender stri
 
 	resp, id, err := mg.Send(rtx, message)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	m_msg := fmt.Sprintf("%s: ID: %s Resp: %s\n", time.Now(), id, resp)
 	log.Printf(m_msg)
-}
-
-// Creates a firestore client.
-func CreateClient(ctx context.Context) *firestore.Client {
-	projectID := "cloudwalk-sre-test"
-	client, err := firestore.NewClient(ctx, projectID)
-	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
-	}
-	return client
+	return nil
 }
 
 // Http Endpoint: Establishes connection to Http-Echo server.
-// Returns a boolean for whether the proper response was received as well as
-// Http specifc logs, and status data stored in firebase.
+// Returns the structured result of the run; Success tells whether the proper response was received.
 // Since spaces in the url will cause a panic, the message sent on this endpoint is trimmed to remove spaces.
-func HttpState(url string, auth string, msg string, timeOut int) (bool, models.GLogs, models.Status) {
-	ctx := context.Background()
-	crt := CreateClient(ctx)
-	defer crt.Close()
-	store := crt.Collection("current_status").Doc("http")
-	note := crt.Collection("config").Doc("config")
+func HttpState(url string, auth string, msg string, timeOut int) (res models.Result) {
+	return httpState(context.Background(), url, auth, msg, timeOut)
+}
+
+// httpState is HttpState with a span for the dial and one for the echo request,
+// which also carries the auth token.
+func httpState(ctx context.Context, url string, auth string, msg string, timeOut int) (res models.Result) {
 	trim := strings.ReplaceAll(msg, " ", "") //we don't want spaces in our http url
 	res_msg := fmt.Sprintf("CLOUDWALK %s", trim)
 	link := fmt.Sprintf("%s/?auth=%s&buf=%s", url, auth, trim)
-	var http_logs models.GLogs
-	var http_stat models.Status
-	var notify models.Notification
-
-	t := time.Now().Format("Mon Jan _2 15:04:05 2006")
+	res.Type = "http"
+	res.Start = time.Now()
+	defer func() { res.Duration = time.Since(res.Start) }()
 
-	dc, err := store.Get(ctx)
+	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
 	if err != nil {
-		log.Fatalf("Failed to Get document: %v", err)
+		Logf(ctx, "Request Error %v", err)
+		fail(&res, models.ErrUnknown, err)
+		return res
 	}
-	dc.DataTo(&http_stat) // read from cloudfirestore into GLogs
-
-	nt, err := note.Get(ctx)
-	if err != nil {
-		log.Fatalf("Failed to Get document: %v", err)
-	}
-	nt.DataTo(&notify) // Reads from firestore into Notification collection
-	http_logs.Email = notify.Email
-	http_logs.Update = notify.Update
+	// we need to know whether we got as far as a connection to classify timeouts.
+	var connected int32
+	// the dial span lasts until we have a connection, the echo span from there
+	// until the answer is read.
+	_, dial := StartSpan(ctx, "dial")
+	var echo trace.Span
+	defer func() {
+		var err error
+		if !res.Success {
+			err = errors.New(res.Error)
+		}
+		if atomic.LoadInt32(&connected) == 0 {
+			endSpan(dial, err)
+		} else {
+			endSpan(echo, err)
+		}
+	}()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			if atomic.LoadInt32(&connected) == 0 {
+				endSpan(dial, nil)
+				_, echo = StartSpan(ctx, "echo")
+				atomic.StoreInt32(&connected, 1)
+			}
+		},
+	}))
+	res.Sent = trim
 
-	req, err := http.NewRequest("GET", link, nil)
-	if err != nil {
-		log.Println("Request Error ", err)
-	} else {
-		auth_ok := fmt.Sprintf("%s: %s", t, "Auth Token Accepted")
-		sent_msg := fmt.Sprintf("%s: Sent: %s", t, trim)
-		http_logs.Auth = auth_ok
-		http_logs.Sent = sent_msg
-	}
 	client := http.Client{
 		Timeout: time.Duration(timeOut) * time.Second, //10 seconds
 	}
-	res, err := client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		log.Println("Error on response \n[Error]: ", err)
+		Logf(ctx, "Error on response \n[Error]: %v", err)
+		fail(&res, Classify(err, atomic.LoadInt32(&connected) == 1), err)
+		return res
 	}
-	defer res.Body.Close()
-	body, err := ioutil.ReadAll(res.Body)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Println("Error reading bytes: ", err)
+		Logf(ctx, "Error reading bytes: %v", err)
+		fail(&res, Classify(err, true), err)
+		return res
 	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		fail(&res, models.ErrAuth, fmt.Errorf("server answered %s", resp.Status))
+		return res
+	}
+	res.Auth = true
+	if resp.StatusCode != http.StatusOK {
+		fail(&res, models.ErrHttpStatus, fmt.Errorf("server answered %s", resp.Status))
+		return res
+	}
+
 	fmtBody := strings.Replace(string(body), "\n", "", -1)
 	m := strings.Replace(fmtBody, "\t", "", -1) //final sanitize
-	is_up := m == res_msg
-	log.Println("http: ", is_up)
-	rec_msg := fmt.Sprintf("%s: Received: %s", t, m)
-	http_logs.Received = rec_msg
-	up_down := fmt.Sprintf("%s: Connection Active: %t", t, is_up)
-	http_logs.State = up_down
-	http_logs.CloudState = is_up
-
-	http_logs.Threshold = msg_http
-	return is_up, http_logs, http_stat
+	res.Received = m
+	res.Success = m == res_msg
+	if !res.Success {
+		fail(&res, models.ErrEchoMismatch, fmt.Errorf("expected %q", res_msg))
+	}
+	Logf(ctx, "http: %v", res.Success)
+	return res
 }
 
-// Tcp Endpoint: TcpState Establishes connection with Tcp-Echo server and return the state, logs and
-// general status of the server stored in firestore.
-func TcpState(host string, port string, auth string, msg string, timeOut int) (bool, models.GLogs, models.Status) {
-	ctx := context.Background()
-	crt := CreateClient(ctx)
-	defer crt.Close()
-	store := crt.Collection("current_status").Doc("tcp")
-	var tcp_stat models.Status
-	var tcp_logs models.GLogs
-
-	t := time.Now().Format("Mon Jan _2 15:04:05 2006")
+// Tcp Endpoint: TcpState Establishes connection with Tcp-Echo server, authenticates,
+// sends msg and returns the structured result of the run.
+func TcpState(host string, port string, auth string, msg string, timeOut int) (res models.Result) {
+	return tcpState(context.Background(), host, port, auth, msg, timeOut)
+}
 
-	dc, err := store.Get(ctx)
-	if err != nil {
-		log.Fatalf("Failed to Get document: %v", err)
-	}
-	dc.DataTo(&tcp_stat)
+// tcpState is TcpState with a span for each of the dial, auth and echo steps.
+func tcpState(ctx context.Context, host string, port string, auth string, msg string, timeOut int) (res models.Result) {
+	res.Type = "tcp"
+	res.Start = time.Now()
+	defer func() { res.Duration = time.Since(res.Start) }()
 
 	res_msg := append([]byte("CLOUDWALK "), []byte(msg)...)
 	out := net.Dialer{
 		Timeout: time.Duration(timeOut) * time.Second,
 	}
-	conn, err := out.Dial("tcp", host+":"+port)
-	defer conn.Close()
+	_, span := StartSpan(ctx, "dial")
+	conn, err := out.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	endSpan(span, err)
 	if err != nil {
-		fmt.Println("Error Connecting: ", err.Error())
-		os.Exit(1)
+		Logf(ctx, "Error Connecting: %s", err.Error())
+		fail(&res, Classify(err, false), err)
+		return res
 	}
-	for {
-		text := fmt.Sprintf("auth %s", auth)
-		//send := append([]byte("auth "), []byte(pass)...)
-		fmt.Fprintf(conn, text+"\n")
-		message, _ := bufio.NewReader(conn).ReadString('\n')
-		if message == "auth ok"+"\n" {
-			auth_ok := fmt.Sprintf("%s: %s", t, "Auth Token Accepted")
-			tcp_logs.Auth = auth_ok
-
-			fmt.Println("Auth Ok")
-			fmt.Fprintf(conn, msg+"\n")
-			log.Printf("Send: %s", msg)
-			sent_msg := fmt.Sprintf("%s: Sent: %s", t, msg)
-			tcp_logs.Sent = sent_msg
-
-			m, _ := bufio.NewReader(conn).ReadBytes('\n')
-			log.Printf("Receive: %s", m)
-			rec_msg := fmt.Sprintf("%s: Received: %s", t, m)
-			tcp_logs.Received = rec_msg
+	defer conn.Close()
+	if timeOut > 0 {
+		conn.SetDeadline(time.Now().Add(time.Duration(timeOut) * time.Second))
+	}
+	reader := bufio.NewReader(conn)
 
-			fmtBody := strings.Replace(string(m), "\n", "", -1)
-			san := strings.Replace(fmtBody, "\t", "", -1) //final sanitize
-			is_up := san == string(res_msg)
-			log.Println("tcp: ", is_up)
-			up_down := fmt.Sprintf("%s: Connection Active: %t", t, is_up)
-			tcp_logs.State = up_down
-			tcp_logs.Threshold = msg_tcp
-			tcp_logs.CloudState = is_up
-			return is_up, tcp_logs, tcp_stat
+	_, span = StartSpan(ctx, "auth")
+	text := fmt.Sprintf("auth %s", auth)
+	fmt.Fprintf(conn, text+"\n")
+	message, err := reader.ReadString('\n')
+	if err != nil {
+		// a server that hangs up on us instead of answering did not like our token.
+		if class := Classify(err, true); class == models.ErrReadTimeout {
+			fail(&res, class, err)
+		} else {
+			fail(&res, models.ErrAuth, err)
+		}
+		endSpan(span, err)
+		return res
+	}
+	if message != "auth ok"+"\n" {
+		fail(&res, models.ErrAuth, fmt.Errorf("server answered %q", strings.TrimSpace(message)))
+		endSpan(span, errors.New(res.Error))
+		return res
+	}
+	res.Auth = true
+	endSpan(span, nil)
+
+	fmt.Println("Auth Ok")
+	_, span = StartSpan(ctx, "echo")
+	defer func() {
+		if res.Success {
+			endSpan(span, nil)
+		} else {
+			endSpan(span, errors.New(res.Error))
+		}
+	}()
+	fmt.Fprintf(conn, msg+"\n")
+	Logf(ctx, "Send: %s", msg)
+	res.Sent = msg
+
+	m, err := reader.ReadBytes('\n')
+	fmtBody := strings.Replace(string(m), "\n", "", -1)
+	san := strings.Replace(fmtBody, "\t", "", -1) //final sanitize
+	Logf(ctx, "Receive: %s", san)
+	res.Received = san
+	if err != nil {
+		if class := Classify(err, true); class == models.ErrReadTimeout {
+			fail(&res, class, err)
 		} else {
-			auth_ok := fmt.Sprintf("%s: %s", t, "Wrong Auth Token")
-			tcp_logs.Auth = auth_ok
-			return false, tcp_logs, tcp_stat
+			fail(&res, models.ErrEchoMismatch, err)
 		}
+		return res
+	}
+	res.Success = san == string(res_msg)
+	if !res.Success {
+		fail(&res, models.ErrEchoMismatch, fmt.Errorf("expected %q", res_msg))
 	}
+	Logf(ctx, "tcp: %v", res.Success)
+	return res
 }
 
-// Checks returns a helper function that represents either HttpState or TcpState. Alongside the function returned
-// is our log warehouse that combines http and tcp logs and data. In here, the logic to increment
-// and decrement health thresholds as well as to send email notification if the thresholds are reached is defined.
+// Checks returns a helper function that represents either HttpState or TcpState. The helper feeds each probe
+// result to the health state machine (see Evaluate), persists the new status and sends email notification for
+// every transition.
 // the tester must explicitly opt in to recieve email notification by entering an email and consenting to receive
 // email notification in the frontend.
-func Checks(ctx context.Context, client *firestore.Client, service_type string, interval int,
-	healthy_threshold int, unhealthy_threshold int) (func(bool, models.GLogs, models.Status), models.LogWarehouse) {
-	store := client.Collection("current_status").Doc(service_type)
-	note := client.Collection("config").Doc("config")
-	var check_logs models.LogWarehouse
-	var notify models.Notification
-	nested := func(f bool, i models.GLogs, h models.Status) {
-
-		t := time.Now().Format("Mon Jan _2 15:04:05 2006")
-		msg_http = ""
-		msg_tcp = ""
-		var status models.Status
-		dc, err := store.Get(ctx)
+func Checks(ctx context.Context, store Store, service_type string,
+	healthy_threshold int, unhealthy_threshold int) func(models.Result) {
+	th := Thresholds{Healthy: healthy_threshold, Unhealthy: unhealthy_threshold}
+	streak := &failureStreak{max: atLeastOne(unhealthy_threshold)}
+	nested := func(res models.Result) {
+		// the check joins the trace of the probe that produced res.
+		ctx := ResultContext(ctx, res)
+		setThreshold(service_type, "")
+		streak.observe(res)
+		pctx, persist := StartSpan(ctx, "persist")
+		_, transitions, err := Advance(pctx, store, service_type, res, th, time.Now())
+		endSpan(persist, err)
 		if err != nil {
-			log.Fatalf("Failed to Get document: %v", err)
+			Logf(ctx, "%s: failed to update status, skipping check: %v", service_type, err)
+			return
 		}
-		dc.DataTo(&status)
 
-		nt, err := note.Get(ctx)
-		if err != nil {
-			log.Fatalf("Failed to Get document: %v", err)
-		}
-		nt.DataTo(&notify)
-
-		is_up := f
-
-		// what to do when server state is unhealthy while is still down; Up;
-		if status.State == "unhealthy" {
-			if !is_up {
-				_, err := store.Set(ctx, map[string]interface{}{
-					"uptime_count":   0,
-					"downtime_count": 0,
-				}, firestore.MergeAll)
-				if err != nil {
-					log.Printf("Set: An error has occurred: %s", err)
-				}
-			} else {
-				_, err := store.Update(ctx, []firestore.Update{{
-					Path: "uptime_count", Value: firestore.Increment(1),
-				}})
-				if err != nil {
-					log.Printf("Update: An error has occurred: %s", err)
-				}
-			}
-		}
-
-		// what to do when server is healthy but down; or up.
-		if status.State == "healthy" {
-			if !is_up {
-				_, err := store.Update(ctx, []firestore.Update{{
-					Path: "downtime_count", Value: firestore.Increment(1),
-				}})
-				if err != nil {
-					log.Printf("Update: An error has occurred: %s", err)
-				}
-			} else {
-				_, err := store.Set(ctx, map[string]interface{}{
-					"downtime_count": 0,
-					"uptime_count":   0,
-				}, firestore.MergeAll)
-				if err != nil {
-					log.Printf("Set: An error has occurred: %s", err)
-				}
-
-			}
-		}
-		// called when unhealthy threshold is reached
-		if status.Downtime == unhealthy_threshold {
-			_, err := store.Set(ctx, map[string]interface{}{
-				"state": "unhealthy",
-			}, firestore.MergeAll)
+		for _, tr := range transitions {
+			nctx, span := StartSpan(ctx, "notify", attribute.String("transition.to", tr.To))
+			record(nctx, store, tr, streak.failures())
+			notify, err := store.GetNotification(nctx)
 			if err != nil {
-				log.Printf("Set: failed to update healthy state: %s", err)
-			}
-			_, er := store.Set(ctx, map[string]interface{}{
-				"timestamp": firestore.ServerTimestamp,
-			}, firestore.MergeAll)
-			if er != nil {
-				log.Printf("Failed to update timestamp: %s", err)
-			}
-
-			_, e := store.Set(ctx, map[string]interface{}{
-				"downtime_count": 0,
-			}, firestore.MergeAll)
-			if e != nil {
-				log.Printf("Set: An error has occurred: %s", err)
-			}
-			if notify.Update && notify.Email != "" {
-				thresh_msg := fmt.Sprintf("%s: Failure Threshold Reached. %s Server is Down. Confirmation Sent!", t, service_type)
-				msg_tcp = thresh_msg
-				msg_http = thresh_msg
-
-				subject := service_type + " Echo Server Down!"
-				body := service_type + " Echo server down. Maximum failure threshold reached" + "\n" + "Will try to make contact again....."
-
-				C, err := ReadConf(filepath.Base("../app.yaml"))
-				if err != nil {
-					log.Fatal(err)
-				}
-				sendMail(ctx, C.Handlers.Domain, C.Handlers.APIKey, C.Handlers.Sender, notify.Email, subject, body)
-			} else {
-				thresh_msg2 := fmt.Sprintf("%s: Failure Threshold Reached. %s Server is Down.", t, service_type)
-				msg_tcp = thresh_msg2
-				msg_http = thresh_msg2
+				Logf(nctx, "Failed to read notification: %v", err)
 			}
+			setThreshold(service_type, announce(nctx, service_type, tr, notify))
+			endSpan(span, err)
 		}
-		// called when healthy threshold reached
-		if status.Uptime == healthy_threshold {
-			_, err := store.Set(ctx, map[string]interface{}{
-				"state": "healthy",
-			}, firestore.MergeAll)
-			if err != nil {
-				log.Printf("Set: failed to update healthy state: %s", err)
-			}
-			_, er := store.Set(ctx, map[string]interface{}{
-				"timestamp": firestore.ServerTimestamp,
-			}, firestore.MergeAll)
-			if er != nil {
-				log.Printf("Failed to update timestamp: %s", err)
-			}
+	}
+	return nested
+}
 
-			_, e := store.Set(ctx, map[string]interface{}{
-				"uptime_count":   0,
-			}, firestore.MergeAll)
-			if e != nil {
-				log.Printf("Set: An error has occurred: %s", err)
-			}
-			if notify.Update && notify.Email != "" {
-				thresh_msg := fmt.Sprintf("%s: Success Threshold Reached! %s Server is Up. Confirmation Sent!", t, service_type)
+// record opens or closes the incident of a transition.
+func record(ctx context.Context, store Store, tr models.Transition, failures []models.Result) {
+	if tr.To == models.StateUnhealthy {
+		inc, err := OpenIncident(ctx, store, tr, failures)
+		if err != nil {
+			Logf(ctx, "%s: failed to open incident: %v", tr.Target, err)
+			return
+		}
+		Logf(ctx, "%s: incident %s opened: %s", tr.Target, inc.ID, inc.Reason)
+		return
+	}
+	inc, closed, err := CloseIncident(ctx, store, tr)
+	if err != nil {
+		Logf(ctx, "%s: failed to close incident: %v", tr.Target, err)
+		return
+	}
+	if closed {
+		Logf(ctx, "%s: incident %s closed after %s", tr.Target, inc.ID, inc.Duration)
+	}
+}
 
-				subject := service_type + " Echo Server Back Online!"
-				body := service_type + " Echo server Back up. Maximum success threshold reached" + "\n" + "Scanning....."
+// announce sends the email notification for a transition if the tester opted in
+// and returns the threshold message to display in the frontend.
+func announce(ctx context.Context, service_type string, tr models.Transition, notify models.Notification) string {
+	var thresh_msg, subject, body string
+	if tr.To == models.StateUnhealthy {
+		thresh_msg = fmt.Sprintf("Failure Threshold Reached. %s Server is Down (%s).", service_type, tr.Cause.ErrorClass)
+		subject = service_type + " Echo Server Down!"
+		body = service_type + " Echo server down. Maximum failure threshold reached" + "\n" +
+			"Last failure: " + string(tr.Cause.ErrorClass) + ": " + tr.Cause.Error + "\n" + "Will try to make contact again....."
+	} else {
+		thresh_msg = fmt.Sprintf("Success Threshold Reached! %s Server is Up!", service_type)
+		subject = service_type + " Echo Server Back Online!"
+		body = service_type + " Echo server Back up. Maximum success threshold reached" + "\n" + "Scanning....."
+	}
+	if notifySubscriber(ctx, NotifyThreshold, notify, subject, body) {
+		thresh_msg += " Confirmation Sent!"
+	}
+	return thresh_msg
+}
 
-				C, err := ReadConf(filepath.Base("../app.yaml"))
-				if err != nil {
-					log.Fatal(err)
-				}
-				sendMail(ctx, C.Handlers.Domain, C.Handlers.APIKey, C.Handlers.Sender, notify.Email, subject, body)
-				msg_tcp = thresh_msg
-				msg_http = thresh_msg
-			} else {
-				thresh_msg2 := fmt.Sprintf("%s: Success Threshold Reached! %s Server is Up!", t, service_type)
-				msg_tcp = thresh_msg2
-				msg_http = thresh_msg2
-			}
-		}
+// notifySubscriber emails subject and body to the tester if they opted in and
+// reports whether the email went out. Attempts are counted in DefaultMetrics
+// by kind.
+func notifySubscriber(ctx context.Context, kind string, notify models.Notification, subject, body string) bool {
+	if !notify.Update || notify.Email == "" {
+		return false
+	}
 
+	mailer.RLock()
+	domain, apiKey, sender := mailer.domain, mailer.apiKey, mailer.sender
+	mailer.RUnlock()
+	if err := sendMail(ctx, domain, apiKey, sender, notify.Email, subject, body); err != nil {
+		log.Printf("Mail: failed to send notification: %s", err)
+		DefaultMetrics.ObserveNotification(kind, false)
+		return false
 	}
-	return nested, check_logs
+	DefaultMetrics.ObserveNotification(kind, true)
+	return true
 }
@@ -2,22 +2,27 @@
 package core
 
 import (
-	"log"
+	"context"
+	"net"
+	"os"
+	"strings"
 	"testing"
+
+	"github.com/icommit/SRETest/pkg/models"
 )
 
 func TestHttpState(t *testing.T) {
-	C, err := ReadConf("../app.yaml")
+	C, err := Load("../app.yaml", EnvLayer(os.Environ()))
 	if err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
 	}
 	host := C.Handlers.TcpUrl
 	port := C.Handlers.Port
 	token := C.Handlers.Token
 	timeout := C.Handlers.Timeout
 	msg := C.Handlers.Msg
-	i, _, _ := TcpState(host, port, token, msg, timeout)
-	if !i {
+	i := TcpState(host, port, token, msg, timeout)
+	if !i.Success {
 		t.Errorf(
 			"unexpected status: got (%v) want (%v)",
 			false,
@@ -27,16 +32,16 @@ func TestHttpState(t *testing.T) {
 }
 
 func TestTcpState(t *testing.T) {
-	C, err := ReadConf("../app.yaml")
+	C, err := Load("../app.yaml", EnvLayer(os.Environ()))
 	if err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
 	}
 	token := C.Handlers.Token
 	timeout := C.Handlers.Timeout
 	msg := C.Handlers.Msg
 
-	i, _, _ := HttpState(C.Handlers.HttpUrl, token, msg, timeout)
-	if !i {
+	i := HttpState(C.Handlers.HttpUrl, token, msg, timeout)
+	if !i.Success {
 		t.Errorf(
 			"unexpected status: got (%v) want (%v)",
 			false,
@@ -44,3 +49,39 @@ func TestTcpState(t *testing.T) {
 		)
 	}
 }
+
+// An unreachable target must end up unhealthy, with the monitor still running.
+func TestChecksUnreachableTarget(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := store.EnsureStatus(ctx, "down"); err != nil {
+		t.Fatal(err)
+	}
+	check := Checks(ctx, store, "down", 2, 2)
+	target := models.Target{Name: "down", Type: "tcp", Address: net.JoinHostPort("127.0.0.1", closedPort(t)), Timeout: 1}
+
+	for i := 0; i < 2; i++ {
+		res, err := Probe(ctx, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(res)
+	}
+	status, err := store.GetStatus(ctx, "down")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != models.StateUnhealthy {
+		t.Errorf("unexpected state: got (%v) want (%v)", status.State, models.StateUnhealthy)
+	}
+	if msg := ThresholdMessage("down"); !strings.Contains(msg, string(models.ErrRefused)) {
+		t.Errorf("unexpected threshold message: %q", msg)
+	}
+	incs, err := store.Incidents(ctx, "down", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incs) != 1 || !incs[0].IsOpen() || incs[0].Reason != models.ErrRefused || len(incs[0].Probes) != 2 {
+		t.Errorf("unexpected incidents: %+v", incs)
+	}
+}
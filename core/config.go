@@ -0,0 +1,431 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/mail"
+	"net/url"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/icommit/SRETest/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults of the env_variables settings every target falls back to.
+const (
+	DefaultTimeout   = 10     // Seconds a probe may take
+	DefaultInterval  = 2      // Seconds between two probes of a target
+	DefaultThreshold = 3      // Probes in a row it takes to change the state of a target
+	DefaultMessage   = "test" // Message sent to the echo servers
+)
+
+// Problem is something wrong with the configuration, found at a yaml path
+// such as "targets[1].address".
+type Problem struct {
+	File    string
+	Line    int // 0 when the path is not in the file
+	Path    string
+	Message string
+}
+
+func (p Problem) String() string {
+	var b strings.Builder
+	if p.File != "" {
+		b.WriteString(p.File)
+		if p.Line > 0 {
+			fmt.Fprintf(&b, ":%d", p.Line)
+		}
+		b.WriteString(": ")
+	}
+	if p.Path != "" {
+		b.WriteString(p.Path + ": ")
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// Problems is every problem found in a configuration. It is the error
+// returned when a configuration is invalid.
+type Problems []Problem
+
+func (ps Problems) Error() string {
+	lines := make([]string, len(ps))
+	for i, p := range ps {
+		lines[i] = p.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Load reads the configuration file f, overrides its env_variables with the
+// layers, in order, reads the secrets given as files, validates the result and
+// fills in the defaults of the settings left out. Problems with a setting
+// given by a layer are reported with the source of the setting.
+func Load(f string, layers ...Layer) (*models.Config, error) {
+	buf, err := ioutil.ReadFile(f)
+	if err != nil {
+		return nil, err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(buf, &root); err != nil {
+		return nil, fmt.Errorf("in file %q: %v", f, err)
+	}
+	c := &models.Config{}
+	if len(bytes.TrimSpace(buf)) > 0 {
+		if err := root.Decode(c); err != nil {
+			return nil, typeProblems(f, err)
+		}
+	}
+	v := &validator{root: &root, file: f, sources: map[string]string{}, unparsed: map[string]bool{}}
+	for _, l := range layers {
+		v.override(c, l)
+	}
+	v.readSecrets(c)
+	if ps := v.validate(c); len(ps) > 0 {
+		return nil, ps
+	}
+	SetDefaults(c)
+	return c, nil
+}
+
+// SetDefaults fills in the env_variables settings left out of c.
+func SetDefaults(c *models.Config) {
+	h := &c.Handlers
+	if h.Timeout == 0 {
+		h.Timeout = DefaultTimeout
+	}
+	if h.Interval == 0 {
+		h.Interval = DefaultInterval
+	}
+	if h.HThreshold == 0 {
+		h.HThreshold = DefaultThreshold
+	}
+	if h.UhThreshold == 0 {
+		h.UhThreshold = DefaultThreshold
+	}
+	if h.Msg == "" {
+		h.Msg = DefaultMessage
+	}
+}
+
+// validator collects the problems of a configuration. root is the yaml
+// document it was decoded from, if any, to find the line of each problem.
+type validator struct {
+	root     *yaml.Node
+	file     string
+	sources  map[string]string // Where the settings not taken from the file came from, by path
+	unparsed map[string]bool   // Settings given by a layer that could not be parsed, by path
+	problems Problems
+}
+
+func (v *validator) validate(c *models.Config) Problems {
+	v.settings(c)
+	// a token given as a file that cannot be read is already reported.
+	h := c.Handlers
+	tokenless := h.Token == "" && h.TokenFile == ""
+	seen := map[string]int{}
+	for i, t := range c.Targets {
+		v.target(i, t, seen)
+		if tokenless && t.Token == "" && t.TokenFile == "" {
+			v.add("is empty and so is env_variables.auth_token, set either or its *_file", "targets", i, "token")
+		}
+	}
+	if len(c.Targets) == 0 {
+		// the default targets are made of env_variables.
+		if tokenless {
+			v.add("is empty, set it or auth_token_file", "env_variables", "auth_token")
+		}
+		if h.TcpUrl == "" {
+			v.add("is required without a targets list", "env_variables", "tcp_url")
+		}
+		if !validPort(h.Port) {
+			v.add(fmt.Sprintf("%q is not a port number", h.Port), "env_variables", "port")
+		}
+		if err := checkURL(h.HttpUrl); err != nil {
+			v.add(err.Error(), "env_variables", "http_url")
+		}
+	}
+	for _, name := range sortedModules(c.Modules) {
+		m := c.Modules[name]
+		if _, ok := Lookup(m.Prober); !ok {
+			v.add(fmt.Sprintf("unknown prober %q, want one of %s", m.Prober, strings.Join(Kinds(), ", ")), "modules", name, "prober")
+		}
+		if m.Token == "" && m.TokenFile == "" {
+			v.add("is required, the token of env_variables is not sent to /probe targets", "modules", name, "token")
+		}
+		if len(m.Hosts) == 0 {
+			v.add(`is required, e.g. ["*.cloudwalk.io"]`, "modules", name, "hosts")
+		}
+		for i, pattern := range m.Hosts {
+			if _, err := path.Match(pattern, ""); err != nil {
+				v.add(fmt.Sprintf("%q is not a valid pattern", pattern), "modules", name, "hosts", i)
+			}
+		}
+		v.atLeast(m.Timeout, 0, "modules", name, "timeout")
+		v.unknownKeys(models.Module{}, "modules", name)
+	}
+	return v.problems
+}
+
+// Helper function checking the env_variables settings.
+func (v *validator) settings(c *models.Config) {
+	h := c.Handlers
+	for _, s := range []struct {
+		key   string
+		value int
+	}{{"timeout", h.Timeout}, {"interval", h.Interval}, {"healthy_threshold", h.HThreshold}, {"unhealthy_threshold", h.UhThreshold}} {
+		v.positive(s.value, "env_variables", s.key)
+	}
+
+	switch h.Storage {
+	case "", BackendFirestore, BackendMemory, BackendBolt:
+	default:
+		v.add(fmt.Sprintf("unknown backend %q, want firestore, memory or bolt", h.Storage), "env_variables", "storage")
+	}
+	switch h.Tracing {
+	case TracingOff, TracingStdout, TracingOTLP:
+	default:
+		v.add(fmt.Sprintf("unknown exporter %q, want stdout or otlp", h.Tracing), "env_variables", "tracing")
+	}
+	if h.TraceRatio < 0 || h.TraceRatio > 1 {
+		v.add(fmt.Sprintf("%g is not between 0 and 1", h.TraceRatio), "env_variables", "trace_ratio")
+	}
+	if h.Domain != "" || h.APIKey != "" {
+		if _, err := mail.ParseAddress(h.Sender); err != nil {
+			v.add(fmt.Sprintf("%q is not an email address", h.Sender), "env_variables", "sender")
+		}
+	}
+	v.objective(h.SLOObjective, h.SLOWindowDays, []interface{}{"env_variables", "slo_objective"}, []interface{}{"env_variables", "slo_window_days"})
+	for _, s := range []struct {
+		key   string
+		value int
+	}{
+		{"lease_ttl", h.LeaseTTL}, {"log_capacity", h.LogCapacity}, {"event_buffer", h.EventBuffer},
+		{"retention_raw_hours", h.RawRetention}, {"retention_minute_days", h.MinuteRetention}, {"retention_hour_days", h.HourRetention},
+	} {
+		v.atLeast(s.value, 0, "env_variables", s.key)
+	}
+	v.unknownKeys(h, "env_variables")
+}
+
+// Helper function checking the target at index i of the targets list.
+func (v *validator) target(i int, t models.Target, seen map[string]int) {
+	switch first, dup := seen[t.Name]; {
+	case t.Name == "":
+		v.add("is required", "targets", i, "name")
+	case dup:
+		v.add(fmt.Sprintf("%q is already the name of targets[%d]", t.Name, first), "targets", i, "name")
+	case strings.Contains(t.Name, "/"):
+		// the name is a segment of the api paths, /api/v1/targets/{name}/status.
+		v.add(fmt.Sprintf("%q contains a /", t.Name), "targets", i, "name")
+	default:
+		seen[t.Name] = i
+	}
+
+	switch _, ok := Lookup(t.Type); {
+	case t.Type == "":
+		v.add(fmt.Sprintf("is required, want one of %s", strings.Join(Kinds(), ", ")), "targets", i, "type")
+	case !ok:
+		v.add(fmt.Sprintf("unknown type %q, want one of %s", t.Type, strings.Join(Kinds(), ", ")), "targets", i, "type")
+	}
+
+	switch {
+	case t.Address == "":
+		v.add("is required", "targets", i, "address")
+	case t.Type == "http":
+		if err := checkURL(t.Address); err != nil {
+			v.add(err.Error(), "targets", i, "address")
+		}
+	case t.Type == "tcp":
+		if host, port, err := net.SplitHostPort(t.Address); err != nil || host == "" || !validPort(port) {
+			v.add(fmt.Sprintf("%q is not a host:port address", t.Address), "targets", i, "address")
+		}
+	}
+
+	v.positive(t.Timeout, "targets", i, "timeout")
+	v.positive(t.Interval, "targets", i, "interval")
+	v.positive(t.HThreshold, "targets", i, "healthy_threshold")
+	v.positive(t.UhThreshold, "targets", i, "unhealthy_threshold")
+	v.objective(t.SLO.Objective, t.SLO.WindowDays, []interface{}{"targets", i, "slo", "objective"}, []interface{}{"targets", i, "slo", "window_days"})
+	v.unknownKeys(models.Target{}, "targets", i)
+	v.unknownKeys(models.SLO{}, "targets", i, "slo")
+}
+
+// Helper function checking an availability objective and its window.
+func (v *validator) objective(objective float64, days int, objectivePath, daysPath []interface{}) {
+	if objective < 0 || objective >= 100 {
+		v.add(fmt.Sprintf("%g is not a percentage below 100", objective), objectivePath...)
+	}
+	v.atLeast(days, 0, daysPath...)
+}
+
+// positive checks a setting that falls back to a default when left out, but
+// is meaningless when set to zero or less.
+func (v *validator) positive(value int, path ...interface{}) {
+	if value < 0 || (value == 0 && v.present(path...)) {
+		v.add(fmt.Sprintf("%d is not at least 1", value), path...)
+	}
+}
+
+func (v *validator) atLeast(value int, min int, path ...interface{}) {
+	if value < min {
+		v.add(fmt.Sprintf("%d is not at least %d", value, min), path...)
+	}
+}
+
+// Helper function reporting the keys of the mapping at path that are not
+// fields of the struct it is decoded into, e.g. a misspelt "intervall".
+func (v *validator) unknownKeys(of interface{}, path ...interface{}) {
+	n := v.lookup(path...)
+	if n == nil || n.Kind != yaml.MappingNode || !v.exact(n, path...) {
+		return
+	}
+	known := map[string]bool{}
+	t := reflect.TypeOf(of)
+	for i := 0; i < t.NumField(); i++ {
+		known[strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]] = true
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := n.Content[i]
+		// App Engine also passes env_variables on as environment variables,
+		// such as CONFIG_FILE, named in upper case.
+		if len(path) == 1 && path[0] == "env_variables" && key.Value == strings.ToUpper(key.Value) {
+			continue
+		}
+		if !known[key.Value] {
+			v.problems = append(v.problems, Problem{File: v.file, Line: key.Line, Path: formatPath(append(path, key.Value)), Message: "unknown setting"})
+		}
+	}
+}
+
+// add records a problem at path, on the line of the closest node of path
+// found in the file, or at the source of the setting when a layer gave it.
+func (v *validator) add(msg string, path ...interface{}) {
+	p := Problem{File: v.file, Path: formatPath(path), Message: msg}
+	if v.unparsed[p.Path] {
+		return // nothing more to say about a value that is not there
+	}
+	if source, ok := v.sources[p.Path]; ok {
+		p.File = source
+	} else if n := v.lookup(path...); n != nil {
+		p.Line = n.Line
+	}
+	v.problems = append(v.problems, p)
+}
+
+// present reports whether path is set in the file or by a layer. Without
+// either, nothing is.
+func (v *validator) present(path ...interface{}) bool {
+	if _, ok := v.sources[formatPath(path)]; ok {
+		return true
+	}
+	return v.exact(v.lookup(path...), path...)
+}
+
+func (v *validator) exact(n *yaml.Node, path ...interface{}) bool {
+	found, depth := v.walk(path)
+	return n != nil && found == n && depth == len(path)
+}
+
+// lookup returns the node at path, or the node of its closest ancestor that is
+// in the file. For a mapping key, the node of the value is returned.
+func (v *validator) lookup(path ...interface{}) *yaml.Node {
+	n, _ := v.walk(path)
+	return n
+}
+
+func (v *validator) walk(path []interface{}) (*yaml.Node, int) {
+	if v.root == nil || len(v.root.Content) == 0 {
+		return nil, 0
+	}
+	n := v.root.Content[0]
+	for depth, step := range path {
+		next := child(n, step)
+		if next == nil {
+			return n, depth
+		}
+		n = next
+	}
+	return n, len(path)
+}
+
+// Helper function returning the value of key step in a mapping, or item step
+// of a sequence.
+func child(n *yaml.Node, step interface{}) *yaml.Node {
+	switch s := step.(type) {
+	case string:
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == s {
+				return n.Content[i+1]
+			}
+		}
+	case int:
+		if n.Kind == yaml.SequenceNode && s < len(n.Content) {
+			return n.Content[s]
+		}
+	}
+	return nil
+}
+
+// Helper function formatting a path as in "targets[1].slo.objective".
+func formatPath(path []interface{}) string {
+	var b strings.Builder
+	for _, step := range path {
+		switch s := step.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", s)
+		default:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			fmt.Fprint(&b, s)
+		}
+	}
+	return b.String()
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 65536
+}
+
+func checkURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https url", s)
+	}
+	return nil
+}
+
+// Helper function turning the errors of decoding values of the wrong type,
+// e.g. "line 9: cannot unmarshal !!str `ten` into int", into Problems.
+func typeProblems(f string, err error) error {
+	te, ok := err.(*yaml.TypeError)
+	if !ok {
+		return fmt.Errorf("in file %q: %v", f, err)
+	}
+	var ps Problems
+	for _, e := range te.Errors {
+		p := Problem{File: f, Message: e}
+		if n, err := fmt.Sscanf(e, "line %d: ", &p.Line); n == 1 && err == nil {
+			p.Message = e[strings.Index(e, ": ")+2:]
+		}
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+func sortedModules(modules map[string]models.Module) []string {
+	names := map[string]bool{}
+	for name := range modules {
+		names[name] = true
+	}
+	return sortedKeys(names)
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Helper function writing a configuration file for Load.
+func writeConf(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConf(t, `
+env_variables:
+  auth_token: "secret"
+  storage: "memory"
+targets:
+  - name: tonto
+    type: tcp
+    address: "tonto.cloudwalk.io:3000"
+    interval: 5
+`)
+	C, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := C.Handlers
+	got := []interface{}{h.Timeout, h.Interval, h.HThreshold, h.UhThreshold, h.Msg}
+	want := []interface{}{DefaultTimeout, DefaultInterval, DefaultThreshold, DefaultThreshold, DefaultMessage}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected defaults: got (%v) want (%v)", got, want)
+	}
+	if target := Targets(C)[0]; target.Interval != 5 || target.Timeout != DefaultTimeout {
+		t.Errorf("unexpected target: got (%+v)", target)
+	}
+
+	// the shipped configuration is valid once the deploy writes its token,
+	// and without the token it is not.
+	token := filepath.Join(t.TempDir(), "auth_token")
+	if err := ioutil.WriteFile(token, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	shipped, err := Load("../app.yaml", Layer{"auth_token_file": {Value: token, Source: "test"}})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if shipped.Handlers.Token != "secret" {
+		t.Errorf("unexpected token: got (%q) want (secret)", shipped.Handlers.Token)
+	}
+	if _, err := Load("../app.yaml", Layer{"auth_token_file": {Value: "", Source: "test"}}); err == nil || !strings.Contains(err.Error(), "env_variables.auth_token: is empty") {
+		t.Errorf("unexpected error: got (%v) want (env_variables.auth_token: is empty)", err)
+	}
+}
+
+func TestLoadProblems(t *testing.T) {
+	path := writeConf(t, `env_variables:
+  timeout: 0
+  interval: -1
+  storage: "redis"
+  tracing: "jaeger"
+  trace_ratio: 2
+  domain: "mg.example.com"
+  sender: "nobody"
+  healthy_treshold: 2
+  CONFIG_FILE: "./targets.yaml"
+targets:
+  - name: tonto
+    type: tcp
+    address: "tonto.cloudwalk.io"
+    intervall: 5
+  - name: tonto
+    token: "t0ken"
+    type: smtp
+    address: "mail.example.com:25"
+    slo:
+      objective: 100
+      window: 7
+  - type: http
+    token: "t0ken"
+    address: "tonto-http.cloudwalk.io"
+modules:
+  echo:
+    prober: udp
+`)
+	_, err := Load(path)
+	problems, ok := err.(Problems)
+	if !ok {
+		t.Fatalf("unexpected error: got (%v) want (Problems)", err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, strings.TrimPrefix(p.String(), path+":"))
+	}
+	want := []string{
+		`2: env_variables.timeout: 0 is not at least 1`,
+		`3: env_variables.interval: -1 is not at least 1`,
+		`4: env_variables.storage: unknown backend "redis", want firestore, memory or bolt`,
+		`5: env_variables.tracing: unknown exporter "jaeger", want stdout or otlp`,
+		`6: env_variables.trace_ratio: 2 is not between 0 and 1`,
+		`8: env_variables.sender: "nobody" is not an email address`,
+		`9: env_variables.healthy_treshold: unknown setting`,
+		`14: targets[0].address: "tonto.cloudwalk.io" is not a host:port address`,
+		`15: targets[0].intervall: unknown setting`,
+		`12: targets[0].token: is empty and so is env_variables.auth_token, set either or its *_file`,
+		`16: targets[1].name: "tonto" is already the name of targets[0]`,
+		`18: targets[1].type: unknown type "smtp", want one of http, tcp`,
+		`21: targets[1].slo.objective: 100 is not a percentage below 100`,
+		`22: targets[1].slo.window: unknown setting`,
+		`23: targets[2].name: is required`,
+		`25: targets[2].address: "tonto-http.cloudwalk.io" is not an http or https url`,
+		`28: modules.echo.prober: unknown prober "udp", want one of http, tcp`,
+		`28: modules.echo.token: is required, the token of env_variables is not sent to /probe targets`,
+		`28: modules.echo.hosts: is required, e.g. ["*.cloudwalk.io"]`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected problems: got (\n%s\n) want (\n%s\n)", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	path = writeConf(t, "env_variables:\n  timeout: ten\n")
+	_, err = Load(path)
+	if want := path + ":2: cannot unmarshal !!str `ten` into int"; err == nil || err.Error() != want {
+		t.Errorf("unexpected error: got (%v) want (%v)", err, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := &models.Config{
+		Targets: []models.Target{
+			{Name: "tonto", Type: "tcp", Address: "tonto.cloudwalk.io:3000"},
+			{Name: "tonto", Type: "http", Address: "https://tonto-http.cloudwalk.io"},
+		},
+	}
+	c.Handlers.Token = "secret"
+	ps := (&validator{}).validate(c)
+	if want := `targets[1].name: "tonto" is already the name of targets[0]`; ps.Error() != want {
+		t.Errorf("unexpected problems: got (%v) want (%v)", ps, want)
+	}
+	c.Targets[1].Name = "tonto/http"
+	ps = (&validator{}).validate(c)
+	if want := `targets[1].name: "tonto/http" contains a /`; ps.Error() != want {
+		t.Errorf("unexpected problems: got (%v) want (%v)", ps, want)
+	}
+	c.Targets = c.Targets[:1]
+	if ps := (&validator{}).validate(c); len(ps) > 0 {
+		t.Errorf("unexpected problems: %v", ps)
+	}
+}
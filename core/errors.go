@@ -0,0 +1,65 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Classify maps a network error to the failure taxonomy. connected tells
+// whether a connection to the target had been established when err occurred,
+// which is what separates a connect timeout from a read timeout.
+func Classify(err error, connected bool) models.ErrorClass {
+	if err == nil {
+		return models.ErrNone
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return models.ErrDNS
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return models.ErrRefused
+	}
+	if isTLSError(err) {
+		return models.ErrTLS
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		if connected {
+			return models.ErrReadTimeout
+		}
+		return models.ErrConnectTimeout
+	}
+	return models.ErrUnknown
+}
+
+// Helper function to tell whether err comes from the TLS handshake or certificate checks.
+func isTLSError(err error) bool {
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &recordErr),
+		errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &certErr):
+		return true
+	}
+	return strings.Contains(err.Error(), "tls: ")
+}
+
+// fail marks res as failed with the given class and error details.
+func fail(res *models.Result, class models.ErrorClass, err error) {
+	res.Success = false
+	res.ErrorClass = class
+	if err != nil {
+		res.Error = err.Error()
+	}
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Helper function that starts a tcp server speaking the echo protocol. reply
+// decides what to answer to each line received; an empty answer hangs up.
+func tcpServer(t *testing.T, reply func(line string) string) (string, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					answer := reply(strings.TrimSuffix(line, "\n"))
+					if answer == "" {
+						return
+					}
+					fmt.Fprint(conn, answer)
+				}
+			}(conn)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	return host, port
+}
+
+// Helper function that returns a local port nobody listens on.
+func closedPort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+	return port
+}
+
+func echo(token string) func(string) string {
+	return func(line string) string {
+		if strings.HasPrefix(line, "auth ") {
+			if line == "auth "+token {
+				return "auth ok\n"
+			}
+			return "auth failed\n"
+		}
+		return "CLOUDWALK " + line + "\n"
+	}
+}
+
+func TestTcpStateClassification(t *testing.T) {
+	okHost, okPort := tcpServer(t, echo("secret"))
+	badHost, badPort := tcpServer(t, func(line string) string {
+		if strings.HasPrefix(line, "auth ") {
+			return "auth ok\n"
+		}
+		return "CLOUDWALK something else\n"
+	})
+	hangHost, hangPort := tcpServer(t, func(line string) string {
+		time.Sleep(3 * time.Second)
+		return "\n"
+	})
+
+	tests := []struct {
+		name  string
+		host  string
+		port  string
+		token string
+		want  models.ErrorClass
+	}{
+		{"healthy", okHost, okPort, "secret", models.ErrNone},
+		{"wrong token", okHost, okPort, "nope", models.ErrAuth},
+		{"wrong echo", badHost, badPort, "secret", models.ErrEchoMismatch},
+		{"silent server", hangHost, hangPort, "secret", models.ErrReadTimeout},
+		{"nobody listening", "127.0.0.1", closedPort(t), "secret", models.ErrRefused},
+		{"unknown host", "tonto.invalid", "3000", "secret", models.ErrDNS},
+	}
+	for _, tt := range tests {
+		res := TcpState(tt.host, tt.port, tt.token, "hello", 1)
+		if res.ErrorClass != tt.want || res.Success != (tt.want == models.ErrNone) {
+			t.Errorf("%s: got (%v, %q) want (%v, %q) [%s]",
+				tt.name, res.Success, res.ErrorClass, tt.want == models.ErrNone, tt.want, res.Error)
+		}
+	}
+}
+
+func TestHttpStateClassification(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("auth") {
+		case "secret":
+			fmt.Fprintf(w, "CLOUDWALK %s\n", r.URL.Query().Get("buf"))
+		case "mismatch":
+			fmt.Fprint(w, "CLOUDWALK nope\n")
+		case "broken":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		default:
+			http.Error(w, "go away", http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+	tls := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tls.Close()
+
+	tests := []struct {
+		name  string
+		url   string
+		token string
+		want  models.ErrorClass
+	}{
+		{"healthy", srv.URL, "secret", models.ErrNone},
+		{"wrong token", srv.URL, "nope", models.ErrAuth},
+		{"wrong echo", srv.URL, "mismatch", models.ErrEchoMismatch},
+		{"server error", srv.URL, "broken", models.ErrHttpStatus},
+		{"untrusted certificate", tls.URL, "secret", models.ErrTLS},
+		{"nobody listening", "http://127.0.0.1:" + closedPort(t), "secret", models.ErrRefused},
+	}
+	for _, tt := range tests {
+		res := HttpState(tt.url, tt.token, "hello", 1)
+		if res.ErrorClass != tt.want || res.Success != (tt.want == models.ErrNone) {
+			t.Errorf("%s: got (%v, %q) want (%v, %q) [%s]",
+				tt.name, res.Success, res.ErrorClass, tt.want == models.ErrNone, tt.want, res.Error)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// ErrFenced is returned by a store for a write made by a leader whose term is
+// over: the lease it was elected with has changed hands since.
+var ErrFenced = errors.New("core: lease token is stale")
+
+// Fence identifies the term of a leader: the lease it holds and the token the
+// lease had when it was elected.
+type Fence struct {
+	Lease string
+	Token int64
+}
+
+type fenceKey struct{}
+
+// WithFence returns a copy of ctx carrying fence. Writes of status, results,
+// incidents and rollups made with it are rejected with ErrFenced once the
+// lease has been granted to another term, if the store keeps the lease.
+func WithFence(ctx context.Context, fence Fence) context.Context {
+	return context.WithValue(ctx, fenceKey{}, fence)
+}
+
+// FenceOf returns the fence carried by ctx, if any.
+func FenceOf(ctx context.Context) (Fence, bool) {
+	fence, ok := ctx.Value(fenceKey{}).(Fence)
+	return fence, ok
+}
+
+// Helper function rejecting a write made with ctx if its fence is from an
+// earlier term of the lease. lease looks the lease up within the transaction
+// of the write, so the lease cannot change hands before the write commits.
+// Writes without a fence pass, and so do those fenced by a lease the store
+// does not keep, such as one of FileLeases.
+func checkFence(ctx context.Context, lease func(name string) (models.Lease, bool, error)) error {
+	fence, ok := FenceOf(ctx)
+	if !ok {
+		return nil
+	}
+	cur, found, err := lease(fence.Lease)
+	if err != nil {
+		return err
+	}
+	if found && cur.Token != fence.Token {
+		return fmt.Errorf("%w: %q is at token %d, not %d", ErrFenced, fence.Lease, cur.Token, fence.Token)
+	}
+	return nil
+}
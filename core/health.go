@@ -0,0 +1,95 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Thresholds holds how many consecutive probes it takes to flip a target's state.
+type Thresholds struct {
+	Healthy   int // successes needed to go from unhealthy to healthy
+	Unhealthy int // failures needed to go from healthy to unhealthy
+}
+
+// Evaluate is the health state machine. Given the current status of a target,
+// the result of its latest probe, the thresholds and the current time, it
+// returns the new status and the transitions it caused. Evaluate is pure: it
+// performs no I/O, so the time is passed in rather than read from a clock.
+//
+// A healthy target counts consecutive failures in Downtime and becomes
+// unhealthy once Downtime reaches the unhealthy threshold. An unhealthy target
+// counts consecutive successes in Uptime and becomes healthy once Uptime
+// reaches the healthy threshold. Any other result resets the counters. A
+// target without a state is considered healthy and thresholds below one
+// behave like one.
+func Evaluate(status models.Status, res models.Result, th Thresholds, now time.Time) (models.Status, []models.Transition) {
+	next := status
+	if next.State != models.StateUnhealthy {
+		next.State = models.StateHealthy
+	}
+
+	switch {
+	case next.State == models.StateHealthy && !res.Success:
+		next.Uptime = 0
+		next.Downtime++
+		if next.Downtime < atLeastOne(th.Unhealthy) {
+			return next, nil
+		}
+		next.State = models.StateUnhealthy
+	case next.State == models.StateUnhealthy && res.Success:
+		next.Downtime = 0
+		next.Uptime++
+		if next.Uptime < atLeastOne(th.Healthy) {
+			return next, nil
+		}
+		next.State = models.StateHealthy
+	default:
+		next.Uptime = 0
+		next.Downtime = 0
+		return next, nil
+	}
+
+	next.Uptime = 0
+	next.Downtime = 0
+	next.Timestamp = now
+	return next, []models.Transition{{
+		Target: res.Target,
+		From:   previousState(status),
+		To:     next.State,
+		At:     now,
+		Cause:  res,
+	}}
+}
+
+// Advance feeds a probe result of the named target to the state machine and
+// persists the outcome. The read, evaluation and write happen atomically in
+// the store, so every transition is emitted exactly once even when several
+// instances or goroutines check the same target at the same time.
+func Advance(ctx context.Context, store StatusStore, name string, res models.Result, th Thresholds, now time.Time) (models.Status, []models.Transition, error) {
+	var transitions []models.Transition
+	next, err := store.UpdateStatus(ctx, name, func(status models.Status) (models.Status, error) {
+		var next models.Status
+		next, transitions = Evaluate(status, res, th, now)
+		return next, nil
+	})
+	if err != nil {
+		return next, nil, err
+	}
+	return next, transitions, nil
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func previousState(status models.Status) string {
+	if status.State == models.StateUnhealthy {
+		return models.StateUnhealthy
+	}
+	return models.StateHealthy
+}
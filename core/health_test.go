@@ -0,0 +1,113 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+var (
+	up   = models.Result{Target: "tonto", Success: true}
+	down = models.Result{Target: "tonto", ErrorClass: models.ErrRefused}
+)
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	before := now.Add(-time.Hour)
+	th := Thresholds{Healthy: 3, Unhealthy: 3}
+
+	tests := []struct {
+		name       string
+		status     models.Status
+		res        models.Result
+		th         Thresholds
+		want       models.Status
+		transition string // target state of the emitted transition, if any
+	}{
+		{"healthy stays healthy", models.Status{State: "healthy", Timestamp: before},
+			up, th, models.Status{State: "healthy", Timestamp: before}, ""},
+		{"healthy success resets failures", models.Status{State: "healthy", Downtime: 2},
+			up, th, models.Status{State: "healthy"}, ""},
+		{"healthy counts a failure", models.Status{State: "healthy", Downtime: 1},
+			down, th, models.Status{State: "healthy", Downtime: 2}, ""},
+		{"healthy failure clears stale successes", models.Status{State: "healthy", Uptime: 2},
+			down, th, models.Status{State: "healthy", Downtime: 1}, ""},
+		{"healthy reaches unhealthy threshold", models.Status{State: "healthy", Downtime: 2, Timestamp: before},
+			down, th, models.Status{State: "unhealthy", Timestamp: now}, "unhealthy"},
+		{"counter above a lowered threshold", models.Status{State: "healthy", Downtime: 7},
+			down, th, models.Status{State: "unhealthy", Timestamp: now}, "unhealthy"},
+		{"unhealthy stays unhealthy", models.Status{State: "unhealthy", Timestamp: before},
+			down, th, models.Status{State: "unhealthy", Timestamp: before}, ""},
+		{"unhealthy failure resets successes", models.Status{State: "unhealthy", Uptime: 2},
+			down, th, models.Status{State: "unhealthy"}, ""},
+		{"unhealthy counts a success", models.Status{State: "unhealthy", Uptime: 1},
+			up, th, models.Status{State: "unhealthy", Uptime: 2}, ""},
+		{"unhealthy success clears stale failures", models.Status{State: "unhealthy", Downtime: 2},
+			up, th, models.Status{State: "unhealthy", Uptime: 1}, ""},
+		{"unhealthy reaches healthy threshold", models.Status{State: "unhealthy", Uptime: 2, Timestamp: before},
+			up, th, models.Status{State: "healthy", Timestamp: now}, "healthy"},
+		{"missing state counts as healthy", models.Status{},
+			down, th, models.Status{State: "healthy", Downtime: 1}, ""},
+		{"unknown state counts as healthy", models.Status{State: "bogus"},
+			up, th, models.Status{State: "healthy"}, ""},
+		{"threshold of one flips at once", models.Status{State: "healthy"},
+			down, Thresholds{Healthy: 1, Unhealthy: 1}, models.Status{State: "unhealthy", Timestamp: now}, "unhealthy"},
+		{"threshold of zero behaves like one", models.Status{State: "unhealthy"},
+			up, Thresholds{}, models.Status{State: "healthy", Timestamp: now}, "healthy"},
+	}
+	for _, tt := range tests {
+		got, transitions := Evaluate(tt.status, tt.res, tt.th, now)
+		if got != tt.want {
+			t.Errorf("%s: unexpected status: got (%+v) want (%+v)", tt.name, got, tt.want)
+		}
+		switch {
+		case tt.transition == "" && len(transitions) != 0:
+			t.Errorf("%s: unexpected transitions: %+v", tt.name, transitions)
+		case tt.transition != "" && len(transitions) != 1:
+			t.Errorf("%s: got %d transitions want 1", tt.name, len(transitions))
+		case tt.transition != "":
+			tr := transitions[0]
+			if tr.To != tt.transition || tr.From == tr.To || !tr.At.Equal(now) || tr.Target != "tonto" || tr.Cause != tt.res {
+				t.Errorf("%s: unexpected transition: %+v", tt.name, tr)
+			}
+		}
+	}
+}
+
+// The threshold is compared against the counter after the current probe has
+// been counted, so a threshold of n flips the state on exactly the nth probe.
+func TestEvaluateFlipsOnNthProbe(t *testing.T) {
+	clock := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	tick := func() time.Time {
+		clock = clock.Add(2 * time.Second)
+		return clock
+	}
+	th := Thresholds{Healthy: 2, Unhealthy: 3}
+	steps := []struct {
+		res  models.Result
+		want string
+		flip bool
+	}{
+		{down, "healthy", false},
+		{down, "healthy", false},
+		{down, "unhealthy", true},
+		{down, "unhealthy", false},
+		{up, "unhealthy", false},
+		{up, "healthy", true},
+		{up, "healthy", false},
+	}
+
+	status := models.Status{State: "healthy"}
+	for i, step := range steps {
+		now := tick()
+		var transitions []models.Transition
+		status, transitions = Evaluate(status, step.res, th, now)
+		if status.State != step.want || (len(transitions) == 1) != step.flip {
+			t.Fatalf("probe %d: got (%s, %d transitions) want (%s, flip %v)", i+1, status.State, len(transitions), step.want, step.flip)
+		}
+		if step.flip && !status.Timestamp.Equal(now) {
+			t.Errorf("probe %d: unexpected timestamp: got (%v) want (%v)", i+1, status.Timestamp, now)
+		}
+	}
+}
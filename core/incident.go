@@ -0,0 +1,116 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// failureStreak remembers the failed probes of a target since its last
+// successful one, so that an incident knows the probes that opened it.
+type failureStreak struct {
+	mu      sync.Mutex
+	max     int
+	results []models.Result
+}
+
+// Helper function that adds res to the streak, or ends it on success. Only
+// the most recent max failures are kept.
+func (s *failureStreak) observe(res models.Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if res.Success {
+		s.results = nil
+		return
+	}
+	s.results = append(s.results, res)
+	if len(s.results) > s.max {
+		s.results = s.results[len(s.results)-s.max:]
+	}
+}
+
+// Helper function returning the failed probes of the streak, oldest first.
+func (s *failureStreak) failures() []models.Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := append([]models.Result(nil), s.results...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
+
+// OpenIncident records the incident started by the transition of a target to
+// unhealthy. probes are the failed probes that led to it; the first one
+// dates the incident and gives its reason. Nothing is recorded if the target
+// already has an open incident.
+func OpenIncident(ctx context.Context, store IncidentStore, tr models.Transition, probes []models.Result) (models.Incident, error) {
+	latest, err := store.Incidents(ctx, tr.Target, 1)
+	if err != nil {
+		return models.Incident{}, err
+	}
+	if len(latest) > 0 && latest[0].IsOpen() {
+		return latest[0], nil
+	}
+
+	if len(probes) == 0 {
+		probes = []models.Result{tr.Cause}
+	}
+	first := probes[0]
+	inc := models.Incident{
+		ID:     fmt.Sprintf("%s-%d", tr.Target, first.Start.UnixNano()),
+		Target: tr.Target,
+		Opened: first.Start,
+		Reason: first.ErrorClass,
+		Error:  first.Error,
+		Probes: probes,
+	}
+	return inc, store.SaveIncident(ctx, inc)
+}
+
+// CloseIncident closes the open incident of a target on its transition back
+// to healthy, counting the probes that ran while it was open. It returns
+// false when there was no open incident.
+func CloseIncident(ctx context.Context, store Store, tr models.Transition) (models.Incident, bool, error) {
+	latest, err := store.Incidents(ctx, tr.Target, 1)
+	if err != nil || len(latest) == 0 || !latest[0].IsOpen() {
+		return models.Incident{}, false, err
+	}
+	inc := latest[0]
+	inc.Closed = tr.At
+	inc.Duration = inc.Closed.Sub(inc.Opened)
+	inc.Total, inc.Failures, err = countProbes(ctx, store, tr.Target, inc.Opened, inc.Closed)
+	if err != nil {
+		return inc, false, err
+	}
+	return inc, true, store.SaveIncident(ctx, inc)
+}
+
+// Stats computes the MTTR and MTBF of a target from its incidents, newest
+// first as returned by IncidentStore. The MTTR only counts closed incidents,
+// the MTBF the time from each recovery to the next incident.
+func Stats(incs []models.Incident) models.IncidentStats {
+	stats := models.IncidentStats{Count: len(incs)}
+	var repair, between time.Duration
+	repaired, gaps := 0, 0
+	for i, inc := range incs {
+		if inc.IsOpen() {
+			continue
+		}
+		repair += inc.Duration
+		repaired++
+		if i > 0 {
+			between += incs[i-1].Opened.Sub(inc.Closed)
+			gaps++
+		}
+	}
+	if repaired > 0 {
+		stats.MTTR = repair / time.Duration(repaired)
+	}
+	if gaps > 0 {
+		stats.MTBF = between / time.Duration(gaps)
+	}
+	return stats
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+func TestIncidentLifecycle(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	base := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return base.Add(time.Duration(s) * time.Second) }
+
+	streak := &failureStreak{max: 2}
+	for s := 0; s < 10; s++ {
+		res := models.Result{Target: "tonto", Start: at(s), Success: s < 2 || s >= 7}
+		if !res.Success {
+			res.ErrorClass = models.ErrRefused
+			if s == 2 {
+				res.ErrorClass = models.ErrReadTimeout
+			}
+		}
+		if err := store.AddResult(ctx, res); err != nil {
+			t.Fatal(err)
+		}
+		if s < 4 {
+			streak.observe(res)
+		}
+	}
+
+	down := models.Transition{Target: "tonto", From: models.StateHealthy, To: models.StateUnhealthy, At: at(3)}
+	inc, err := OpenIncident(ctx, store, down, streak.failures())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inc.IsOpen() || !inc.Opened.Equal(at(2)) || inc.Reason != models.ErrReadTimeout || len(inc.Probes) != 2 {
+		t.Errorf("unexpected opened incident: %+v", inc)
+	}
+	// a second transition while open keeps the incident.
+	if again, err := OpenIncident(ctx, store, down, nil); err != nil || again.ID != inc.ID {
+		t.Errorf("unexpected incident: got (%v, %v) want (%v)", again.ID, err, inc.ID)
+	}
+
+	up := models.Transition{Target: "tonto", From: models.StateUnhealthy, To: models.StateHealthy, At: at(8)}
+	inc, closed, err := CloseIncident(ctx, store, up)
+	if err != nil || !closed {
+		t.Fatalf("unexpected close: got (%v, %v) want (%v, %v)", closed, err, true, nil)
+	}
+	if inc.Duration != 6*time.Second || inc.Total != 6 || inc.Failures != 5 {
+		t.Errorf("unexpected closed incident: %+v", inc)
+	}
+	if _, closed, _ := CloseIncident(ctx, store, up); closed {
+		t.Errorf("unexpected close without an open incident")
+	}
+
+	incs, err := store.Incidents(ctx, "tonto", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incs) != 1 || incs[0].IsOpen() || incs[0].ID != inc.ID {
+		t.Errorf("unexpected incidents: %+v", incs)
+	}
+}
+
+func TestIncidentStats(t *testing.T) {
+	base := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return base.Add(time.Duration(m) * time.Minute) }
+	incident := func(opened, closed int) models.Incident {
+		inc := models.Incident{Opened: at(opened)}
+		if closed > 0 {
+			inc.Closed = at(closed)
+			inc.Duration = inc.Closed.Sub(inc.Opened)
+		}
+		return inc
+	}
+
+	tests := []struct {
+		incs       []models.Incident
+		mttr, mtbf time.Duration
+	}{
+		{nil, 0, 0},
+		{[]models.Incident{incident(0, 0)}, 0, 0},
+		{[]models.Incident{incident(10, 12)}, 2 * time.Minute, 0},
+		// newest first: up 10 minutes, then 30 minutes between the incidents
+		{[]models.Incident{incident(60, 0), incident(20, 24), incident(0, 10)}, 7 * time.Minute, 23 * time.Minute},
+	}
+	for _, tt := range tests {
+		stats := Stats(tt.incs)
+		if stats.Count != len(tt.incs) || stats.MTTR != tt.mttr || stats.MTBF != tt.mtbf {
+			t.Errorf("unexpected stats: got (%+v) want (%v, %v)", stats, tt.mttr, tt.mtbf)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package core
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/icommit/SRETest/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the
+// configuration, e.g. MONITOR_TIMEOUT for timeout of env_variables. Without
+// it, port would clash with the PORT App Engine serves on.
+const EnvPrefix = "MONITOR_"
+
+// Sections of the configuration whose entries are overridden by name, e.g.
+// MONITOR_TARGETS__TONTO__TOKEN or -set targets.tonto.token=... for the token
+// of the target named tonto. Entries cannot be added this way.
+var namedSections = []string{"targets", "modules"}
+
+// Settings given as files, by the setting they stand for. Each secret can be
+// given either inline or as the path of a file holding it, so it need not be
+// committed along with the configuration.
+var secretFiles = map[string]string{"auth_token_file": "auth_token", "api_key_file": "api_key", "token_file": "token"}
+
+// Redacted replaces the value of secrets in a dump of the configuration.
+const Redacted = "REDACTED"
+
+// Override is the value of a setting of env_variables given outside of the
+// configuration file.
+type Override struct {
+	Value  string
+	Source string // Where it was given, e.g. "MONITOR_TIMEOUT" or "flag -timeout"
+}
+
+// Layer is a set of overrides, by key. The key of a setting of env_variables
+// is its name, e.g. "timeout", and that of a field of a target or module its
+// path by name, e.g. "targets.tonto.slo.objective". Layers are applied on top
+// of the configuration file in order, so a later layer wins.
+type Layer map[string]Override
+
+// Settings returns the names of the settings of env_variables, in the order
+// of models.Config.
+func Settings() []string {
+	t := reflect.TypeOf(models.Config{}.Handlers)
+	names := make([]string, t.NumField())
+	for i := range names {
+		names[i] = strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+	}
+	return names
+}
+
+// EnvLayer returns the overrides given in environ, a list of key=value
+// pairs such as os.Environ. A setting of env_variables is named after it in
+// upper case, e.g. MONITOR_HEALTHY_THRESHOLD. A field of a target or module
+// is named after its path by name with double underscores, e.g.
+// MONITOR_TARGETS__TONTO__SLO__OBJECTIVE; the name matches in upper case with
+// every character other than a letter or digit replaced by an underscore.
+func EnvLayer(environ []string) Layer {
+	settings := map[string]string{}
+	for _, name := range Settings() {
+		settings[strings.ToUpper(name)] = name
+	}
+	l := Layer{}
+	for _, kv := range environ {
+		i := strings.Index(kv, "=")
+		if i < 0 || !strings.HasPrefix(kv[:i], EnvPrefix) {
+			continue
+		}
+		key, value := kv[:i], kv[i+1:]
+		rest := strings.TrimPrefix(key, EnvPrefix)
+		if name, ok := settings[rest]; ok {
+			l[name] = Override{Value: value, Source: key}
+			continue
+		}
+		for _, section := range namedSections {
+			prefix := strings.ToUpper(section) + "__"
+			if parts := strings.Split(strings.TrimPrefix(rest, prefix), "__"); strings.HasPrefix(rest, prefix) && len(parts) > 1 {
+				field := strings.ToLower(strings.Join(parts[1:], "."))
+				l[section+"."+parts[0]+"."+field] = Override{Value: value, Source: key}
+			}
+		}
+	}
+	return l
+}
+
+// Flags defines a flag on fs for every setting of env_variables, named after
+// it with dashes, e.g. -healthy-threshold, and a -set flag taking any key of a
+// Layer, e.g. -set targets.tonto.timeout=5, that may be repeated and wins over
+// the others. The returned function gives the layer of the flags set once fs
+// is parsed.
+func Flags(fs *flag.FlagSet) func() Layer {
+	t := reflect.TypeOf(models.Config{}.Handlers)
+	names := map[string]string{}
+	for i, name := range Settings() {
+		flagName := strings.ReplaceAll(name, "_", "-")
+		names[flagName] = name
+		fs.Var(&settingFlag{bool: t.Field(i).Type.Kind() == reflect.Bool}, flagName, "overrides "+name+" of env_variables")
+	}
+	var set setFlag
+	fs.Var(&set, "set", "overrides the setting at key, e.g. targets.tonto.token=..., may be repeated")
+	return func() Layer {
+		l := Layer{}
+		fs.Visit(func(f *flag.Flag) {
+			if name, ok := names[f.Name]; ok {
+				l[name] = Override{Value: f.Value.String(), Source: "flag -" + f.Name}
+			}
+		})
+		for _, kv := range set {
+			i := strings.Index(kv, "=")
+			l[kv[:i]] = Override{Value: kv[i+1:], Source: "flag -set " + kv[:i]}
+		}
+		return l
+	}
+}
+
+// setFlag holds the key=value pairs of the -set flag, in order.
+type setFlag []string
+
+func (f *setFlag) String() string { return strings.Join(*f, " ") }
+
+func (f *setFlag) Set(s string) error {
+	if i := strings.Index(s, "="); i <= 0 {
+		return fmt.Errorf("%q is not key=value", s)
+	}
+	*f = append(*f, s)
+	return nil
+}
+
+// settingFlag holds the value of a setting flag as given. It is parsed along
+// with the other layers, so its problems are reported the same way.
+type settingFlag struct {
+	value string
+	bool  bool
+}
+
+func (f *settingFlag) String() string     { return f.value }
+func (f *settingFlag) Set(s string) error { f.value = s; return nil }
+func (f *settingFlag) IsBoolFlag() bool   { return f.bool }
+
+// Helper function setting what a layer overrides: the env_variables and the
+// fields of the targets and modules it names. Overrides matching none of them
+// are reported.
+func (v *validator) override(c *models.Config, l Layer) {
+	used := map[string]bool{}
+	v.overrideFields(reflect.ValueOf(&c.Handlers).Elem(), l, used, []string{""}, "env_variables")
+	for i := range c.Targets {
+		v.overrideFields(reflect.ValueOf(&c.Targets[i]).Elem(), l, used, namedKeys("targets", c.Targets[i].Name), "targets", i)
+	}
+	for _, name := range sortedModules(c.Modules) {
+		m := c.Modules[name]
+		v.overrideFields(reflect.ValueOf(&m).Elem(), l, used, namedKeys("modules", name), "modules", name)
+		c.Modules[name] = m
+	}
+
+	keys := make([]string, 0, len(l))
+	for key := range l {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !used[key] {
+			v.problems = append(v.problems, Problem{File: l[key].Source, Path: key, Message: "matches no setting, target or module"})
+		}
+	}
+}
+
+// Helper function returning the prefixes of the keys overriding the fields of
+// the entry of section with the given name: as named, and as named in
+// environment variables.
+func namedKeys(section string, name string) []string {
+	env := strings.Map(func(r rune) rune {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+	return []string{section + "." + name + ".", section + "." + env + "."}
+}
+
+// Helper function setting the fields of the struct s that l overrides, under
+// any of prefixes, and recording them in used. path is the path of s in the
+// configuration. Setting a secret replaces the file of the secret given by a
+// lower layer, and the other way around.
+func (v *validator) overrideFields(s reflect.Value, l Layer, used map[string]bool, prefixes []string, path ...interface{}) {
+	given := func(name string) (string, bool) {
+		for _, prefix := range prefixes {
+			if _, ok := l[prefix+name]; ok {
+				return prefix + name, true
+			}
+		}
+		return "", false
+	}
+	t := s.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		fieldPath := append(append([]interface{}{}, path...), name)
+		if s.Field(i).Kind() == reflect.Struct {
+			sub := make([]string, len(prefixes))
+			for j, prefix := range prefixes {
+				sub[j] = prefix + name + "."
+			}
+			v.overrideFields(s.Field(i), l, used, sub, fieldPath...)
+			continue
+		}
+		key, ok := given(name)
+		if !ok {
+			continue
+		}
+		used[key] = true
+		o := l[key]
+		p := formatPath(fieldPath)
+		v.sources[p] = o.Source
+		v.forget(p) // a value of a lower layer that could not be parsed
+		if err := setValue(s.Field(i), o.Value); err != nil {
+			v.add(err.Error(), fieldPath...)
+			v.unparsed[p] = true
+			continue
+		}
+		other := name + "_file"
+		if secret, ok := secretFiles[name]; ok {
+			other = secret
+		}
+		if _, same := given(other); same {
+			continue
+		}
+		for j := 0; j < t.NumField(); j++ {
+			if strings.Split(t.Field(j).Tag.Get("yaml"), ",")[0] == other {
+				s.Field(j).Set(reflect.Zero(s.Field(j).Type()))
+				delete(v.sources, formatPath(append(append([]interface{}{}, path...), other)))
+			}
+		}
+	}
+}
+
+func (v *validator) forget(path string) {
+	delete(v.unparsed, path)
+	kept := v.problems[:0]
+	for _, p := range v.problems {
+		if p.Path != path {
+			kept = append(kept, p)
+		}
+	}
+	v.problems = kept
+}
+
+// Helper function parsing s into a setting of the kind of field.
+func setValue(field reflect.Value, s string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", s)
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", s)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		// a list of strings, given separated by commas.
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	}
+	return nil
+}
+
+// Helper function replacing every secret given as a file with the content of
+// the file, without its trailing newline.
+func (v *validator) readSecrets(c *models.Config) {
+	h := &c.Handlers
+	h.Token = v.readSecret(h.Token, h.TokenFile, "env_variables", "auth_token")
+	h.APIKey = v.readSecret(h.APIKey, h.APIKeyFile, "env_variables", "api_key")
+	for i := range c.Targets {
+		t := &c.Targets[i]
+		t.Token = v.readSecret(t.Token, t.TokenFile, "targets", i, "token")
+	}
+	for _, name := range sortedModules(c.Modules) {
+		m := c.Modules[name]
+		m.Token = v.readSecret(m.Token, m.TokenFile, "modules", name, "token")
+		c.Modules[name] = m
+	}
+}
+
+func (v *validator) readSecret(value, file string, path ...interface{}) string {
+	if file == "" {
+		return value
+	}
+	key := path[len(path)-1].(string)
+	filePath := append(append([]interface{}{}, path[:len(path)-1]...), key+"_file")
+	if value != "" {
+		v.add(fmt.Sprintf("set only one of %s and %s_file", key, key), filePath...)
+		return value
+	}
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		v.add(err.Error(), filePath...)
+		return value
+	}
+	return strings.TrimRight(string(buf), "\r\n")
+}
+
+// Dump returns the configuration c as yaml, with every secret redacted.
+func Dump(c *models.Config) ([]byte, error) {
+	d := *c
+	redact(&d.Handlers.Token)
+	redact(&d.Handlers.APIKey)
+	d.Targets = append([]models.Target(nil), c.Targets...)
+	for i := range d.Targets {
+		redact(&d.Targets[i].Token)
+	}
+	if c.Modules != nil {
+		d.Modules = map[string]models.Module{}
+		for name, m := range c.Modules {
+			redact(&m.Token)
+			d.Modules[name] = m
+		}
+	}
+	var b bytes.Buffer
+	e := yaml.NewEncoder(&b)
+	e.SetIndent(2)
+	if err := e.Encode(&d); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), e.Close()
+}
+
+func redact(s *string) {
+	if *s != "" {
+		*s = Redacted
+	}
+}
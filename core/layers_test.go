@@ -0,0 +1,168 @@
+package core
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+func TestLoadLayers(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(secret, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := writeConf(t, `env_variables:
+  auth_token: "from-yaml"
+  timeout: 5
+  interval: 5
+  storage: "memory"
+targets:
+  - name: tonto
+    type: tcp
+    address: "tonto.cloudwalk.io:3000"
+    token_file: "`+secret+`"
+`)
+	env := []string{"MONITOR_INTERVAL=3", "MONITOR_HEALTHY_THRESHOLD=4", "MONITOR_AUTH_TOKEN_FILE=" + secret, "INTERVAL=9"}
+	fs := flag.NewFlagSet("monitor", flag.ContinueOnError)
+	flags := Flags(fs)
+	if err := fs.Parse([]string{"-healthy-threshold", "2", "-otlp-insecure"}); err != nil {
+		t.Fatal(err)
+	}
+
+	C, err := Load(path, EnvLayer(env), flags())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := C.Handlers
+	got := []interface{}{h.Token, h.Timeout, h.Interval, h.HThreshold, h.OTLPInsecure, C.Targets[0].Token}
+	want := []interface{}{"from-file", 5, 3, 2, true, "from-file"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected settings: got (%v) want (%v)", got, want)
+	}
+
+	// a secret given by a higher layer replaces the file of a lower one.
+	C, err = Load(path, EnvLayer(env), Layer{"auth_token": {Value: "from-flag", Source: "flag -auth-token"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if C.Handlers.Token != "from-flag" || C.Handlers.TokenFile != "" {
+		t.Errorf("unexpected token: got (%q, %q) want (from-flag, \"\")", C.Handlers.Token, C.Handlers.TokenFile)
+	}
+}
+
+func TestLoadLayersTargetsModules(t *testing.T) {
+	path := writeConf(t, `env_variables:
+  auth_token: "from-yaml"
+  storage: "memory"
+targets:
+  - name: tonto.cloudwalk
+    type: tcp
+    address: "tonto.cloudwalk.io:3000"
+    token_file: "/nonexistent/token"
+    slo:
+      objective: 99.9
+modules:
+  echo:
+    prober: tcp
+    hosts: ["*.cloudwalk.io"]
+    token: "from-yaml"
+`)
+	env := []string{
+		"MONITOR_TARGETS__TONTO_CLOUDWALK__TOKEN=from-env",
+		"MONITOR_TARGETS__TONTO_CLOUDWALK__SLO__OBJECTIVE=99.5",
+		"MONITOR_MODULES__ECHO__HOSTS=*.cloudwalk.io, *.example.com",
+	}
+	fs := flag.NewFlagSet("monitor", flag.ContinueOnError)
+	flags := Flags(fs)
+	if err := fs.Parse([]string{"-set", "targets.tonto.cloudwalk.timeout=7", "-set", "modules.echo.token=from-flag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	C, err := Load(path, EnvLayer(env), flags())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	target, module := C.Targets[0], C.Modules["echo"]
+	got := []interface{}{target.Token, target.TokenFile, target.Timeout, target.SLO.Objective, module.Token, module.Hosts}
+	want := []interface{}{"from-env", "", 7, 99.5, "from-flag", []string{"*.cloudwalk.io", "*.example.com"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected settings: got (%v) want (%v)", got, want)
+	}
+
+	fs.SetOutput(ioutil.Discard)
+	if err := fs.Parse([]string{"-set", "interval"}); err == nil {
+		t.Errorf("unexpected error: got (nil) want (not key=value)")
+	}
+	_, err = Load(path, Layer{"targets.tonto.token": {Value: "x", Source: "flag -set targets.tonto.token"}})
+	problems, ok := err.(Problems)
+	want0 := "flag -set targets.tonto.token: targets.tonto.token: matches no setting, target or module"
+	if !ok || problems[0].String() != want0 {
+		t.Errorf("unexpected error: got (%v) want (%s)", err, want0)
+	}
+}
+
+func TestLoadLayersProblems(t *testing.T) {
+	path := writeConf(t, `env_variables:
+  auth_token: "inline"
+  storage: "memory"
+targets:
+  - name: tonto
+    type: tcp
+    address: "tonto.cloudwalk.io:3000"
+    token: "inline"
+    token_file: "/nonexistent/token"
+`)
+	env := Layer{
+		"timeout":      {Value: "ten", Source: "MONITOR_TIMEOUT"},
+		"interval":     {Value: "ten", Source: "MONITOR_INTERVAL"},
+		"api_key_file": {Value: "/nonexistent/key", Source: "MONITOR_API_KEY_FILE"},
+	}
+	flags := Layer{"interval": {Value: "0", Source: "flag -interval"}}
+	_, err := Load(path, env, flags)
+	problems, ok := err.(Problems)
+	if !ok {
+		t.Fatalf("unexpected error: got (%v) want (Problems)", err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	want := []string{
+		`MONITOR_TIMEOUT: env_variables.timeout: "ten" is not an integer`,
+		"MONITOR_API_KEY_FILE: env_variables.api_key_file: open /nonexistent/key: no such file or directory",
+		path + ":9: targets[0].token_file: set only one of token and token_file",
+		"flag -interval: env_variables.interval: 0 is not at least 1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected problems: got (\n%s\n) want (\n%s\n)", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDump(t *testing.T) {
+	c := &models.Config{
+		Targets: []models.Target{{Name: "tonto", Type: "tcp", Address: "tonto.cloudwalk.io:3000", Token: "t0ken"}},
+		Modules: map[string]models.Module{"echo": {Prober: "tcp", Token: "m0dule"}},
+	}
+	c.Handlers.Token, c.Handlers.APIKey, c.Handlers.Domain = "s3cret", "k3y", "mg.example.com"
+	buf, err := Dump(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, secret := range []string{"t0ken", "m0dule", "s3cret", "k3y"} {
+		if strings.Contains(string(buf), secret) {
+			t.Errorf("secret %q in dump:\n%s", secret, buf)
+		}
+	}
+	if !strings.Contains(string(buf), "  auth_token: "+Redacted+"\n") || !strings.Contains(string(buf), "domain: mg.example.com") {
+		t.Errorf("unexpected dump:\n%s", buf)
+	}
+	if c.Handlers.Token != "s3cret" || c.Targets[0].Token != "t0ken" || c.Modules["echo"].Token != "m0dule" {
+		t.Errorf("dump changed the configuration: %+v", c)
+	}
+}
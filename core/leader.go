@@ -0,0 +1,180 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Leader runs a lease based leader election. App Engine may start several
+// instances of the monitor; only the one holding the lease runs the probe
+// scheduler while the others just serve the frontend. The leader renews its
+// lease three times per TTL and steps down a sixth of the TTL before the lease
+// expires if it cannot. When it dies, the lease expires and a follower takes
+// over within TTL plus one renewal period.
+type Leader struct {
+	Leases LeaseStore       // Where the lease is kept
+	Name   string           // Name of the lease
+	ID     string           // Identifies this instance as the lease holder
+	TTL    time.Duration    // How long a lease lasts without renewal
+	Now    func() time.Time // Clock. Defaults to time.Now
+
+	mu      sync.Mutex
+	lease   models.Lease
+	leading bool
+}
+
+// InstanceID returns a name for this instance to hold leases under.
+func InstanceID() string {
+	if id := os.Getenv("GAE_INSTANCE"); id != "" {
+		return id
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// IsLeader reports whether this instance currently holds the lease.
+func (l *Leader) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leading
+}
+
+// Lease returns the lease as last seen by this instance.
+func (l *Leader) Lease() models.Lease {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lease
+}
+
+func (l *Leader) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+// Run takes part in the election until ctx is done. Whenever this instance
+// becomes leader, lead is started with a context that is cancelled as soon as
+// leadership is lost; Run waits for lead to return before trying again. The
+// context carries the Fence of the term, so what lead writes with it is
+// rejected once another instance has been elected, even if it is still
+// finishing up. The lease is released when ctx is done so a follower can take
+// over at once.
+//
+// A leader that cannot renew its lease steps down on a local timer, a margin
+// before the lease it holds expires, whether or not the renewals return in
+// time. Each renewal is cut short before that timer fires.
+func (l *Leader) Run(ctx context.Context, lead func(ctx context.Context)) {
+	var cancel context.CancelFunc
+	var done chan struct{}
+
+	// stepping down takes a little time, and clocks of other instances may
+	// run ahead of ours, so leadership ends a margin before the lease does.
+	margin := l.TTL / 6
+	expiry := time.NewTimer(0)
+	<-expiry.C
+	defer expiry.Stop()
+	var deadline time.Time // when leadership ends unless the lease is renewed
+
+	stepDown := func() {
+		if cancel == nil {
+			return
+		}
+		cancel()
+		<-done
+		cancel = nil
+		l.mu.Lock()
+		l.leading = false
+		l.mu.Unlock()
+		log.Printf("leader: %s stepped down from %q", l.ID, l.Name)
+	}
+
+	ticker := time.NewTicker(l.TTL / 3)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		timeout := l.TTL / 3
+		if cancel != nil {
+			if left := deadline.Sub(start); left < timeout {
+				timeout = left
+			}
+		}
+		var lease models.Lease
+		var ok bool
+		var err error
+		if timeout > 0 {
+			rctx, rcancel := context.WithTimeout(ctx, timeout)
+			lease, ok, err = l.Leases.AcquireLease(rctx, l.Name, l.ID, l.TTL, l.now())
+			rcancel()
+		} else {
+			err = context.DeadlineExceeded
+		}
+		switch {
+		case cancel != nil && !time.Now().Before(deadline):
+			// too late, whatever the answer: a follower may already lead.
+			if err == nil && ok {
+				err = errors.New("renewed too late")
+			}
+			log.Printf("leader: lease %q expiring: %v", l.Name, err)
+			stepDown()
+		case err != nil:
+			// we cannot tell whether someone else took over, so keep leading
+			// only until the lease we hold is about to expire.
+			log.Printf("leader: failed to renew lease %q: %v", l.Name, err)
+		case ok:
+			// the lease lasts at least TTL from when it was asked for.
+			deadline = start.Add(l.TTL - margin)
+			if !expiry.Stop() {
+				select {
+				case <-expiry.C:
+				default:
+				}
+			}
+			expiry.Reset(deadline.Sub(time.Now()))
+			l.mu.Lock()
+			l.lease = lease
+			l.mu.Unlock()
+			if cancel == nil {
+				log.Printf("leader: %s elected for %q with token %d", l.ID, l.Name, lease.Token)
+				leadCtx, leadCancel := context.WithCancel(WithFence(ctx, Fence{Lease: l.Name, Token: lease.Token}))
+				cancel, done = leadCancel, make(chan struct{})
+				l.mu.Lock()
+				l.leading = true
+				l.mu.Unlock()
+				go func(done chan struct{}) {
+					defer close(done)
+					lead(leadCtx)
+				}(done)
+			}
+		default:
+			l.mu.Lock()
+			l.lease = lease
+			l.mu.Unlock()
+			stepDown()
+		}
+
+		select {
+		case <-ctx.Done():
+			stepDown()
+			rctx, rcancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := l.Leases.ReleaseLease(rctx, l.Name, l.ID); err != nil {
+				log.Printf("leader: failed to release lease %q: %v", l.Name, err)
+			}
+			rcancel()
+			return
+		case <-expiry.C:
+			if cancel != nil {
+				log.Printf("leader: lease %q expiring without renewal", l.Name)
+				stepDown()
+			}
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// crashable wraps a LeaseStore and fails every call once crashed, the way an
+// instance that lost its network or died looks to everybody else.
+type crashable struct {
+	LeaseStore
+	crashed int32
+}
+
+func (c *crashable) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration, now time.Time) (models.Lease, bool, error) {
+	if atomic.LoadInt32(&c.crashed) == 1 {
+		return models.Lease{}, false, errors.New("crashed")
+	}
+	return c.LeaseStore.AcquireLease(ctx, name, holder, ttl, now)
+}
+
+// hanging wraps a LeaseStore and, once hung, blocks every renewal until its
+// context is done, like a storage call that never answers.
+type hanging struct {
+	LeaseStore
+	hung int32
+}
+
+func (h *hanging) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration, now time.Time) (models.Lease, bool, error) {
+	if atomic.LoadInt32(&h.hung) == 1 {
+		<-ctx.Done()
+		return models.Lease{}, false, ctx.Err()
+	}
+	return h.LeaseStore.AcquireLease(ctx, name, holder, ttl, now)
+}
+
+func (c *crashable) ReleaseLease(ctx context.Context, name string, holder string) error {
+	if atomic.LoadInt32(&c.crashed) == 1 {
+		return errors.New("crashed")
+	}
+	return c.LeaseStore.ReleaseLease(ctx, name, holder)
+}
+
+// Helper function that waits up to d for cond to hold.
+func eventually(d time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestLeaderFailover(t *testing.T) {
+	const ttl = 150 * time.Millisecond
+	store := NewMemoryStore()
+	a := &Leader{Leases: &crashable{LeaseStore: store}, Name: "scheduler", ID: "a", TTL: ttl}
+	b := &Leader{Leases: store, Name: "scheduler", ID: "b", TTL: ttl}
+
+	var running int32
+	lead := func(ctx context.Context) {
+		if atomic.AddInt32(&running, 1) > 1 {
+			t.Error("two leaders at once")
+		}
+		<-ctx.Done()
+		atomic.AddInt32(&running, -1)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Run(ctx, lead)
+	if !eventually(time.Second, a.IsLeader) {
+		t.Fatal("a was never elected")
+	}
+	go b.Run(ctx, lead)
+	time.Sleep(2 * ttl)
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("unexpected leaders: a (%v) b (%v)", a.IsLeader(), b.IsLeader())
+	}
+	token := a.Lease().Token
+
+	// a dies without releasing its lease; b must take over once it expires.
+	atomic.StoreInt32(&a.Leases.(*crashable).crashed, 1)
+	start := time.Now()
+	if !eventually(2*ttl+ttl/3+100*time.Millisecond, b.IsLeader) {
+		t.Fatal("b did not take over")
+	}
+	if took := time.Since(start); took < ttl/2 {
+		t.Errorf("b took over before a's lease expired: %v", took)
+	}
+	if a.IsLeader() {
+		t.Error("a still believes it leads")
+	}
+	if got := b.Lease().Token; got <= token {
+		t.Errorf("lease token did not grow: got (%d) want > (%d)", got, token)
+	}
+}
+
+// lead runs with the fence of the term it was elected for.
+func TestLeaderFence(t *testing.T) {
+	store := NewMemoryStore()
+	l := &Leader{Leases: store, Name: "scheduler", ID: "a", TTL: time.Second}
+	fences := make(chan Fence, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.Run(ctx, func(ctx context.Context) {
+		fence, _ := FenceOf(ctx)
+		fences <- fence
+		<-ctx.Done()
+	})
+
+	select {
+	case fence := <-fences:
+		if want := (Fence{Lease: "scheduler", Token: l.Lease().Token}); fence != want || fence.Token == 0 {
+			t.Errorf("unexpected fence: got (%+v) want (%+v)", fence, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a was never elected")
+	}
+}
+
+func TestLeaderReleasesOnShutdown(t *testing.T) {
+	const ttl = 3 * time.Second
+	store := NewFileLeases(filepath.Join(t.TempDir(), "leases.json"))
+	a := &Leader{Leases: store, Name: "scheduler", ID: "a", TTL: ttl}
+	b := &Leader{Leases: store, Name: "scheduler", ID: "b", TTL: ttl}
+
+	actx, stop := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		a.Run(actx, func(ctx context.Context) { <-ctx.Done() })
+		close(stopped)
+	}()
+	if !eventually(time.Second, a.IsLeader) {
+		t.Fatal("a was never elected")
+	}
+	bctx, cancel := context.WithCancel(context.Background())
+	bstopped := make(chan struct{})
+	defer func() { cancel(); <-bstopped }() // b releases the lease in the temp dir
+	go func() {
+		b.Run(bctx, func(ctx context.Context) { <-ctx.Done() })
+		close(bstopped)
+	}()
+
+	stop()
+	<-stopped
+	// released, so b only has to wait for its next renewal, not the whole ttl.
+	if !eventually(ttl/3+500*time.Millisecond, b.IsLeader) {
+		t.Fatal("b did not take over after a released the lease")
+	}
+}
+
+// A leader whose renewals hang steps down before its lease expires, so a
+// follower taking over the expired lease never leads along with it.
+func TestLeaderStepsDownOnHungRenewal(t *testing.T) {
+	const ttl = 300 * time.Millisecond
+	store := NewMemoryStore()
+	a := &Leader{Leases: &hanging{LeaseStore: store}, Name: "scheduler", ID: "a", TTL: ttl}
+	b := &Leader{Leases: store, Name: "scheduler", ID: "b", TTL: ttl}
+
+	var running int32
+	lead := func(ctx context.Context) {
+		if atomic.AddInt32(&running, 1) > 1 {
+			t.Error("two leaders at once")
+		}
+		<-ctx.Done()
+		atomic.AddInt32(&running, -1)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Run(ctx, lead)
+	if !eventually(time.Second, a.IsLeader) {
+		t.Fatal("a was never elected")
+	}
+	go b.Run(ctx, lead)
+
+	atomic.StoreInt32(&a.Leases.(*hanging).hung, 1)
+	expires := a.Lease().Expires
+	if !eventually(2*ttl, func() bool { return !a.IsLeader() }) {
+		t.Fatal("a kept leading with its renewals hung")
+	}
+	if now := time.Now(); !now.Before(expires) {
+		t.Errorf("a stepped down after its lease expired: %v late", now.Sub(expires))
+	}
+	if !eventually(2*ttl, b.IsLeader) {
+		t.Fatal("b did not take over")
+	}
+}
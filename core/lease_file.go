@@ -0,0 +1,76 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// FileLeases is a LeaseStore kept in a json file on local disk. Every access
+// holds an exclusive lock on the file, which lets processes on one machine
+// elect a leader when their store cannot be shared, like the memory backend.
+type FileLeases struct {
+	path string
+}
+
+// NewFileLeases returns a FileLeases using the lock file at path.
+func NewFileLeases(path string) *FileLeases {
+	return &FileLeases{path: path}
+}
+
+// Helper function to read, modify and write the lease file under the lock.
+func (f *FileLeases) update(fn func(leases map[string]models.Lease)) error {
+	file, err := os.OpenFile(f.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := lockFile(file); err != nil {
+		return err
+	}
+	defer unlockFile(file)
+
+	leases := make(map[string]models.Lease)
+	buf, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if len(buf) > 0 {
+		if err := json.Unmarshal(buf, &leases); err != nil {
+			return err
+		}
+	}
+	fn(leases)
+	if buf, err = json.Marshal(leases); err != nil {
+		return err
+	}
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = file.WriteAt(buf, 0)
+	return err
+}
+
+func (f *FileLeases) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration, now time.Time) (models.Lease, bool, error) {
+	var next models.Lease
+	var ok bool
+	err := f.update(func(leases map[string]models.Lease) {
+		cur, found := leases[name]
+		next, ok = grantLease(cur, found, holder, ttl, now)
+		leases[name] = next
+	})
+	return next, ok, err
+}
+
+func (f *FileLeases) ReleaseLease(ctx context.Context, name string, holder string) error {
+	return f.update(func(leases map[string]models.Lease) {
+		if cur, found := leases[name]; found && cur.Holder == holder {
+			cur.Expires = time.Time{}
+			leases[name] = cur
+		}
+	})
+}
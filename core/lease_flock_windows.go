@@ -0,0 +1,14 @@
+package core
+
+import (
+	"errors"
+	"os"
+)
+
+func lockFile(f *os.File) error {
+	return errors.New("core: lease files are not supported on windows")
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
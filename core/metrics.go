@@ -0,0 +1,306 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Upper bounds in seconds of the probe duration histogram buckets.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Kinds of notification counted by the metrics.
+const (
+	NotifyThreshold = "threshold" // A target reached its healthy or unhealthy threshold
+	NotifyBurnRate  = "burn_rate" // A burn-rate alert started or stopped firing
+	NotifyStall     = "stall"     // The probe loop of a target stalled or recovered
+)
+
+// DefaultMetrics collects the metrics of the monitor.
+var DefaultMetrics = NewMetrics()
+
+// Metrics collects probe outcomes and latency, the health of every target and
+// notification counts, and writes them in the Prometheus text exposition
+// format. It is safe for concurrent use.
+type Metrics struct {
+	mu            sync.Mutex
+	probes        map[probeKey]uint64
+	durations     map[string]*histogram // by target
+	status        map[string]models.Status
+	stalled       map[string]bool
+	notifications map[notificationKey]uint64
+}
+
+type probeKey struct {
+	target, result, class string
+}
+
+type notificationKey struct {
+	kind, result string
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewMetrics returns empty metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		probes:        make(map[probeKey]uint64),
+		durations:     make(map[string]*histogram),
+		status:        make(map[string]models.Status),
+		stalled:       make(map[string]bool),
+		notifications: make(map[notificationKey]uint64),
+	}
+}
+
+// ObserveProbe counts a probe result and its duration.
+func (m *Metrics) ObserveProbe(res models.Result) {
+	key := probeKey{target: res.Target, result: "success", class: "none"}
+	if !res.Success {
+		key.result, key.class = "failure", string(res.ErrorClass)
+		if key.class == "" {
+			key.class = string(models.ErrUnknown)
+		}
+	}
+	seconds := res.Duration.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.probes[key]++
+	h := m.durations[res.Target]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(durationBuckets)+1)}
+		m.durations[res.Target] = h
+	}
+	h.counts[sort.SearchFloat64s(durationBuckets, seconds)]++
+	h.count++
+	h.sum += seconds
+}
+
+// SetStatus sets the health and threshold counters of a target.
+func (m *Metrics) SetStatus(target string, status models.Status) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status[target] = status
+}
+
+// Forget drops every metric of a target that is no longer monitored.
+func (m *Metrics) Forget(target string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.probes {
+		if key.target == target {
+			delete(m.probes, key)
+		}
+	}
+	delete(m.durations, target)
+	delete(m.status, target)
+	delete(m.stalled, target)
+}
+
+// SetStalled sets whether the probe loop of a target is stalled.
+func (m *Metrics) SetStalled(target string, stalled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stalled[target] = stalled
+}
+
+// ObserveNotification counts a notification of kind that was sent, or failed
+// to be sent.
+func (m *Metrics) ObserveNotification(kind string, sent bool) {
+	key := notificationKey{kind: kind, result: "sent"}
+	if !sent {
+		key.result = "failed"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifications[key]++
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := &encoder{w: bufio.NewWriter(w)}
+
+	targets := make([]string, 0, len(m.status))
+	for target := range m.status {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	e.family("monitor_target_healthy", "gauge", "Whether the target is healthy (1) or unhealthy (0).")
+	for _, target := range targets {
+		healthy := 0.0
+		if m.status[target].State != models.StateUnhealthy {
+			healthy = 1
+		}
+		e.sample("monitor_target_healthy", healthy, "target", target)
+	}
+	e.family("monitor_threshold_uptime_count", "gauge", "Consecutive successes counted towards the healthy threshold.")
+	for _, target := range targets {
+		e.sample("monitor_threshold_uptime_count", float64(m.status[target].Uptime), "target", target)
+	}
+	e.family("monitor_threshold_downtime_count", "gauge", "Consecutive failures counted towards the unhealthy threshold.")
+	for _, target := range targets {
+		e.sample("monitor_threshold_downtime_count", float64(m.status[target].Downtime), "target", target)
+	}
+
+	targets = targets[:0]
+	for target := range m.stalled {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	e.family("monitor_target_stalled", "gauge", "Whether the probe loop of the target has stalled (1) or not (0).")
+	for _, target := range targets {
+		e.sample("monitor_target_stalled", boolFloat(m.stalled[target]), "target", target)
+	}
+
+	probes := make([]probeKey, 0, len(m.probes))
+	for key := range m.probes {
+		probes = append(probes, key)
+	}
+	sort.Slice(probes, func(i, j int) bool {
+		a, b := probes[i], probes[j]
+		if a.target != b.target {
+			return a.target < b.target
+		}
+		if a.result != b.result {
+			return a.result > b.result // success first
+		}
+		return a.class < b.class
+	})
+	e.family("monitor_probes_total", "counter", "Probes run, by result and error class.")
+	for _, key := range probes {
+		e.sample("monitor_probes_total", float64(m.probes[key]), "target", key.target, "result", key.result, "error_class", key.class)
+	}
+
+	targets = targets[:0]
+	for target := range m.durations {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	e.family("monitor_probe_duration_seconds", "histogram", "How long probes took.")
+	for _, target := range targets {
+		h := m.durations[target]
+		var cumulative uint64
+		for i, le := range durationBuckets {
+			cumulative += h.counts[i]
+			e.sample("monitor_probe_duration_seconds_bucket", float64(cumulative), "target", target, "le", formatFloat(le))
+		}
+		e.sample("monitor_probe_duration_seconds_bucket", float64(h.count), "target", target, "le", "+Inf")
+		e.sample("monitor_probe_duration_seconds_sum", h.sum, "target", target)
+		e.sample("monitor_probe_duration_seconds_count", float64(h.count), "target", target)
+	}
+
+	notifications := make([]notificationKey, 0, len(m.notifications))
+	for key := range m.notifications {
+		notifications = append(notifications, key)
+	}
+	sort.Slice(notifications, func(i, j int) bool {
+		a, b := notifications[i], notifications[j]
+		return a.kind < b.kind || (a.kind == b.kind && a.result > b.result)
+	})
+	e.family("monitor_notifications_total", "counter", "Email notifications, by kind and whether they were sent.")
+	for _, key := range notifications {
+		e.sample("monitor_notifications_total", float64(m.notifications[key]), "kind", key.kind, "result", key.result)
+	}
+
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	return e.n, e.err
+}
+
+// WriteProbeMetrics writes the outcome of a single probe in the Prometheus text
+// exposition format, in the style of the blackbox exporter.
+func WriteProbeMetrics(w io.Writer, res models.Result) error {
+	e := &encoder{w: bufio.NewWriter(w)}
+	e.family("probe_success", "gauge", "Whether the probe was a success.")
+	e.sample("probe_success", boolFloat(res.Success))
+	e.family("probe_duration_seconds", "gauge", "How long the probe took to complete in seconds.")
+	e.sample("probe_duration_seconds", res.Duration.Seconds())
+	e.family("probe_echo_auth_accepted", "gauge", "Whether the echo server accepted the auth token.")
+	e.sample("probe_echo_auth_accepted", boolFloat(res.Auth))
+	e.family("probe_echo_error_class", "gauge", "Why the probe failed. Only present on failure.")
+	if !res.Success {
+		class := string(res.ErrorClass)
+		if class == "" {
+			class = string(models.ErrUnknown)
+		}
+		e.sample("probe_echo_error_class", 1, "error_class", class)
+	}
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	return e.err
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// encoder writes the text exposition format, remembering the first error.
+type encoder struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (e *encoder) printf(format string, args ...interface{}) {
+	if e.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(e.w, format, args...)
+	e.n += int64(n)
+	e.err = err
+}
+
+func (e *encoder) family(name, kind, help string) {
+	e.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Helper function that writes a sample with labels given as name, value pairs.
+func (e *encoder) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i == 0 {
+			b.WriteByte('{')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(labels[i+1]))
+		b.WriteByte('"')
+	}
+	if len(labels) > 0 {
+		b.WriteByte('}')
+	}
+	e.printf("%s %s\n", b.String(), formatFloat(value))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	m.SetStatus("tonto", models.Status{State: models.StateHealthy, Uptime: 2})
+	m.SetStatus(`we"b`, models.Status{State: models.StateUnhealthy, Downtime: 1})
+	m.SetStalled("tonto", true)
+	m.ObserveProbe(models.Result{Target: "tonto", Success: true, Duration: 3 * time.Millisecond})
+	m.ObserveProbe(models.Result{Target: "tonto", Success: true, Duration: 200 * time.Millisecond})
+	m.ObserveProbe(models.Result{Target: "tonto", ErrorClass: models.ErrReadTimeout, Duration: time.Minute})
+	m.ObserveNotification(NotifyThreshold, true)
+	m.ObserveNotification(NotifyThreshold, false)
+	m.ObserveNotification(NotifyThreshold, true)
+
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP monitor_target_healthy Whether the target is healthy (1) or unhealthy (0).
+# TYPE monitor_target_healthy gauge
+monitor_target_healthy{target="tonto"} 1
+monitor_target_healthy{target="we\"b"} 0
+# HELP monitor_threshold_uptime_count Consecutive successes counted towards the healthy threshold.
+# TYPE monitor_threshold_uptime_count gauge
+monitor_threshold_uptime_count{target="tonto"} 2
+monitor_threshold_uptime_count{target="we\"b"} 0
+# HELP monitor_threshold_downtime_count Consecutive failures counted towards the unhealthy threshold.
+# TYPE monitor_threshold_downtime_count gauge
+monitor_threshold_downtime_count{target="tonto"} 0
+monitor_threshold_downtime_count{target="we\"b"} 1
+# HELP monitor_target_stalled Whether the probe loop of the target has stalled (1) or not (0).
+# TYPE monitor_target_stalled gauge
+monitor_target_stalled{target="tonto"} 1
+# HELP monitor_probes_total Probes run, by result and error class.
+# TYPE monitor_probes_total counter
+monitor_probes_total{target="tonto",result="success",error_class="none"} 2
+monitor_probes_total{target="tonto",result="failure",error_class="read_timeout"} 1
+# HELP monitor_probe_duration_seconds How long probes took.
+# TYPE monitor_probe_duration_seconds histogram
+monitor_probe_duration_seconds_bucket{target="tonto",le="0.005"} 1
+monitor_probe_duration_seconds_bucket{target="tonto",le="0.01"} 1
+monitor_probe_duration_seconds_bucket{target="tonto",le="0.025"} 1
+monitor_probe_duration_seconds_bucket{target="tonto",le="0.05"} 1
+monitor_probe_duration_seconds_bucket{target="tonto",le="0.1"} 1
+monitor_probe_duration_seconds_bucket{target="tonto",le="0.25"} 2
+monitor_probe_duration_seconds_bucket{target="tonto",le="0.5"} 2
+monitor_probe_duration_seconds_bucket{target="tonto",le="1"} 2
+monitor_probe_duration_seconds_bucket{target="tonto",le="2.5"} 2
+monitor_probe_duration_seconds_bucket{target="tonto",le="5"} 2
+monitor_probe_duration_seconds_bucket{target="tonto",le="10"} 2
+monitor_probe_duration_seconds_bucket{target="tonto",le="30"} 2
+monitor_probe_duration_seconds_bucket{target="tonto",le="+Inf"} 3
+monitor_probe_duration_seconds_sum{target="tonto"} 60.203
+monitor_probe_duration_seconds_count{target="tonto"} 3
+# HELP monitor_notifications_total Email notifications, by kind and whether they were sent.
+# TYPE monitor_notifications_total counter
+monitor_notifications_total{kind="threshold",result="sent"} 2
+monitor_notifications_total{kind="threshold",result="failed"} 1
+`
+	if got := b.String(); got != want {
+		t.Errorf("unexpected metrics: got\n%s\nwant\n%s", got, want)
+	}
+
+	m.Forget("tonto")
+	b.Reset()
+	m.WriteTo(&b)
+	if strings.Contains(b.String(), `"tonto"`) {
+		t.Errorf("metrics of a forgotten target remain:\n%s", b.String())
+	}
+}
@@ -0,0 +1,241 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Prober is implemented by every check type the monitor knows how to run.
+// A Prober makes contact with a single target and reports what happened.
+type Prober interface {
+	Probe(ctx context.Context, target models.Target) models.Result
+}
+
+// ProberFunc lets an ordinary function be used as a Prober.
+type ProberFunc func(ctx context.Context, target models.Target) models.Result
+
+// Probe calls f(ctx, target).
+func (f ProberFunc) Probe(ctx context.Context, target models.Target) models.Result {
+	return f(ctx, target)
+}
+
+var (
+	probersMu sync.RWMutex
+	probers   = make(map[string]Prober)
+)
+
+// Register makes a Prober available under the given type name. Targets whose
+// type matches the name are probed with it. Like database/sql, Register panics
+// if called twice with the same name or with a nil Prober.
+func Register(kind string, p Prober) {
+	probersMu.Lock()
+	defer probersMu.Unlock()
+	if p == nil {
+		panic("core: Register prober is nil")
+	}
+	if _, dup := probers[kind]; dup {
+		panic("core: Register called twice for prober " + kind)
+	}
+	probers[kind] = p
+}
+
+// unregister removes the Prober registered for kind, so tests can register
+// their own without leaking it into Kinds.
+func unregister(kind string) {
+	probersMu.Lock()
+	defer probersMu.Unlock()
+	delete(probers, kind)
+}
+
+// Lookup returns the Prober registered for kind.
+func Lookup(kind string) (Prober, bool) {
+	probersMu.RLock()
+	defer probersMu.RUnlock()
+	p, ok := probers[kind]
+	return p, ok
+}
+
+// Kinds returns the sorted names of all registered probe types.
+func Kinds() []string {
+	probersMu.RLock()
+	defer probersMu.RUnlock()
+	kinds := make([]string, 0, len(probers))
+	for k := range probers {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// Probe runs the Prober registered for the target's type. A Prober that panics
+// produces a failed result instead of taking the whole monitor down with it.
+func Probe(ctx context.Context, target models.Target) (res models.Result, err error) {
+	p, ok := Lookup(target.Type)
+	if !ok {
+		return models.Result{}, fmt.Errorf("core: unknown probe type %q for target %q", target.Type, target.Name)
+	}
+
+	ctx, span := StartSpan(ctx, "probe",
+		attribute.String("target", target.Name),
+		attribute.String("type", target.Type),
+		attribute.String("address", target.Address))
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			Logf(ctx, "%s: probe panicked: %v\n%s", target.Name, r, debug.Stack())
+			res = models.Result{Type: target.Type, Start: start, Duration: time.Since(start)}
+			fail(&res, models.ErrUnknown, fmt.Errorf("probe panicked: %v", r))
+		}
+		res.Target = target.Name
+		if sc := span.SpanContext(); sc.IsSampled() {
+			res.TraceID, res.SpanID = sc.TraceID().String(), sc.SpanID().String()
+		}
+		span.SetAttributes(attribute.Bool("success", res.Success), attribute.String("error_class", string(res.ErrorClass)))
+		if !res.Success {
+			span.SetStatus(codes.Error, res.Error)
+		}
+		span.End()
+	}()
+	return p.Probe(ctx, target), nil
+}
+
+// Targets returns the targets described by the configuration with every unset
+// field filled in from env_variables. Without a targets list, the tcp and http
+// echo servers from env_variables are returned.
+func Targets(c *models.Config) []models.Target {
+	h := c.Handlers
+	targets := c.Targets
+	if len(targets) == 0 {
+		targets = []models.Target{
+			{Name: "tcp", Type: "tcp", Address: net.JoinHostPort(h.TcpUrl, h.Port)},
+			{Name: "http", Type: "http", Address: h.HttpUrl},
+		}
+	}
+
+	out := make([]models.Target, 0, len(targets))
+	for _, t := range targets {
+		if t.Token == "" {
+			t.Token = h.Token
+		}
+		if t.Message == "" {
+			t.Message = h.Msg
+		}
+		if t.Timeout == 0 {
+			t.Timeout = h.Timeout
+		}
+		if t.Interval == 0 {
+			t.Interval = h.Interval
+		}
+		if t.HThreshold == 0 {
+			t.HThreshold = h.HThreshold
+		}
+		if t.UhThreshold == 0 {
+			t.UhThreshold = h.UhThreshold
+		}
+		if t.SLO.Objective == 0 {
+			t.SLO.Objective = h.SLOObjective
+		}
+		if t.SLO.WindowDays == 0 {
+			t.SLO.WindowDays = h.SLOWindowDays
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// ModuleTarget returns the target that the module named module of the
+// configuration checks at address, with its message and timeout filled in
+// from env_variables when unset. The address must be on a host the module
+// allows, and the module must have a token of its own: the token of
+// env_variables is never sent to an address given by a /probe caller.
+func ModuleTarget(c *models.Config, module string, address string) (models.Target, error) {
+	m, ok := c.Modules[module]
+	if !ok {
+		return models.Target{}, fmt.Errorf("unknown module %q", module)
+	}
+	if _, ok := Lookup(m.Prober); !ok {
+		return models.Target{}, fmt.Errorf("module %q: unknown prober %q", module, m.Prober)
+	}
+	if m.Token == "" {
+		return models.Target{}, fmt.Errorf("module %q has no token", module)
+	}
+	if !HostAllowed(m.Hosts, m.Prober, address) {
+		return models.Target{}, fmt.Errorf("module %q may not probe %q", module, address)
+	}
+	h := c.Handlers
+	target := models.Target{
+		Name:    address,
+		Type:    m.Prober,
+		Address: address,
+		Token:   m.Token,
+		Message: m.Message,
+		Timeout: m.Timeout,
+	}
+	if target.Message == "" {
+		target.Message = h.Msg
+	}
+	if target.Timeout == 0 {
+		target.Timeout = h.Timeout
+	}
+	return target, nil
+}
+
+// HostAllowed reports whether the host of address, a base url for http and
+// host:port otherwise, matches one of the patterns, in the syntax of
+// path.Match. Host names are compared regardless of case.
+func HostAllowed(patterns []string, kind string, address string) bool {
+	host := address
+	if kind == "http" {
+		u, err := url.Parse(address)
+		if err != nil {
+			return false
+		}
+		host = u.Hostname()
+	} else if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+	if host == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(host)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Http echo check. The target address is the base url of the echo server.
+func probeHttp(ctx context.Context, target models.Target) models.Result {
+	res := httpState(ctx, target.Address, target.Token, target.Message, target.Timeout)
+	res.Target = target.Name
+	return res
+}
+
+// Tcp echo check. The target address is host:port of the echo server.
+func probeTcp(ctx context.Context, target models.Target) models.Result {
+	host, port, err := net.SplitHostPort(target.Address)
+	if err != nil {
+		host, port = target.Address, ""
+	}
+	res := tcpState(ctx, host, port, target.Token, target.Message, target.Timeout)
+	res.Target = target.Name
+	return res
+}
+
+func init() {
+	Register("http", ProberFunc(probeHttp))
+	Register("tcp", ProberFunc(probeTcp))
+}
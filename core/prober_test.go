@@ -0,0 +1,86 @@
+package core
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+func TestProbeUnreachableTarget(t *testing.T) {
+	addr := net.JoinHostPort("127.0.0.1", closedPort(t))
+	targets := []models.Target{
+		{Name: "tcp-down", Type: "tcp", Address: addr, Timeout: 1},
+		{Name: "http-down", Type: "http", Address: "http://" + addr, Timeout: 1},
+		{Name: "tcp-nowhere", Type: "tcp", Address: "tonto.invalid:3000", Timeout: 1},
+	}
+	for _, target := range targets {
+		res, err := Probe(context.Background(), target)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", target.Name, err)
+		}
+		if res.Success || res.ErrorClass == models.ErrNone || res.Target != target.Name {
+			t.Errorf("%s: got (%v, %q, %q) want a failed result", target.Name, res.Success, res.ErrorClass, res.Target)
+		}
+	}
+}
+
+func TestProbePanicBecomesFailedResult(t *testing.T) {
+	Register("test-panic", ProberFunc(func(ctx context.Context, target models.Target) models.Result {
+		var conn net.Conn
+		conn.Close() // nil dereference, like the old TcpState on a failed dial
+		return models.Result{Success: true}
+	}))
+	t.Cleanup(func() { unregister("test-panic") })
+
+	res, err := Probe(context.Background(), models.Target{Name: "boom", Type: "test-panic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Success || res.ErrorClass != models.ErrUnknown || res.Target != "boom" {
+		t.Errorf("unexpected result: got (%v, %q, %q) want (false, %q, %q)",
+			res.Success, res.ErrorClass, res.Target, models.ErrUnknown, "boom")
+	}
+}
+
+func TestProbeUnknownType(t *testing.T) {
+	if _, err := Probe(context.Background(), models.Target{Name: "x", Type: "smtp"}); err == nil {
+		t.Error("expected an error for an unregistered probe type")
+	}
+}
+
+func TestModuleTarget(t *testing.T) {
+	c := &models.Config{Modules: map[string]models.Module{
+		"tcp_echo":  {Prober: "tcp", Hosts: []string{"*.cloudwalk.io"}, Token: "module-secret", Timeout: 3},
+		"http_echo": {Prober: "http", Hosts: []string{"tonto-http.cloudwalk.io"}, Token: "module-secret"},
+		"no_token":  {Prober: "tcp", Hosts: []string{"*"}},
+	}}
+	c.Handlers.Token = "secret"
+	c.Handlers.Msg = "hello"
+	c.Handlers.Timeout = 30
+
+	target, err := ModuleTarget(c, "tcp_echo", "tonto.cloudwalk.io:3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.Target{Name: "tonto.cloudwalk.io:3000", Type: "tcp", Address: "tonto.cloudwalk.io:3000", Token: "module-secret", Message: "hello", Timeout: 3}
+	if target != want {
+		t.Errorf("unexpected target: got (%+v) want (%+v)", target, want)
+	}
+	if target, err := ModuleTarget(c, "http_echo", "https://TONTO-HTTP.cloudwalk.io/echo"); err != nil || target.Timeout != 30 {
+		t.Errorf("unexpected target: got (%+v, %v)", target, err)
+	}
+
+	for _, tt := range []struct{ module, address, want string }{
+		{"missing", "tonto.cloudwalk.io:3000", `unknown module "missing"`},
+		{"no_token", "tonto.cloudwalk.io:3000", `module "no_token" has no token`},
+		{"tcp_echo", "attacker.example.com:3000", `module "tcp_echo" may not probe "attacker.example.com:3000"`},
+		{"tcp_echo", "cloudwalk.io.example.com:3000", `module "tcp_echo" may not probe "cloudwalk.io.example.com:3000"`},
+		{"http_echo", "https://attacker.example.com/?tonto-http.cloudwalk.io", `module "http_echo" may not probe "https://attacker.example.com/?tonto-http.cloudwalk.io"`},
+	} {
+		if _, err := ModuleTarget(c, tt.module, tt.address); err == nil || err.Error() != tt.want {
+			t.Errorf("unexpected error: got (%v) want (%v)", err, tt.want)
+		}
+	}
+}
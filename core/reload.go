@@ -0,0 +1,202 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// How long the configuration file has to stay untouched before it is reloaded.
+// Editors and deploy tools often write a file in several steps.
+const reloadDelay = 250 * time.Millisecond
+
+// Settings of env_variables holding secrets. Their values are never logged.
+var secretSettings = map[string]bool{"auth_token": true, "token": true, "api_key": true}
+
+// Settings of env_variables that apply without a restart: target defaults
+// are resolved again on reload and mail credentials are read on every send.
+var liveSettings = map[string]bool{
+	"auth_token": true, "auth_token_file": true, "tcp_url": true, "port": true, "http_url": true, "message": true, "timeout": true,
+	"interval": true, "healthy_threshold": true, "unhealthy_threshold": true,
+	"slo_objective": true, "slo_window_days": true,
+	"sender": true, "recipient": true, "domain": true, "api_key": true, "api_key_file": true,
+}
+
+// ConfigDiff is what changed between two versions of the configuration.
+type ConfigDiff struct {
+	Added    []models.Target
+	Removed  []models.Target
+	Changed  []TargetChange
+	Modules  []string // One line per module added, removed or changed
+	Settings []string // One line per changed setting of env_variables
+}
+
+// TargetChange is a target whose configuration changed.
+type TargetChange struct {
+	Old, New models.Target
+	Fields   []string // What changed, e.g. "interval: 2 -> 5"
+}
+
+// DiffConfig compares the targets, modules and settings of two versions of
+// the configuration. Targets are compared after their defaults are resolved
+// and matched by name.
+func DiffConfig(old, new *models.Config) ConfigDiff {
+	var d ConfigDiff
+	before := map[string]models.Target{}
+	for _, t := range Targets(old) {
+		before[t.Name] = t
+	}
+	after := map[string]bool{}
+	for _, t := range Targets(new) {
+		after[t.Name] = true
+		prev, ok := before[t.Name]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, t)
+		case !reflect.DeepEqual(prev, t):
+			d.Changed = append(d.Changed, TargetChange{Old: prev, New: t, Fields: diffFields("", prev, t)})
+		}
+	}
+	for _, t := range Targets(old) {
+		if !after[t.Name] {
+			d.Removed = append(d.Removed, t)
+		}
+	}
+
+	names := map[string]bool{}
+	for name := range old.Modules {
+		names[name] = true
+	}
+	for name := range new.Modules {
+		names[name] = true
+	}
+	for _, name := range sortedKeys(names) {
+		prev, was := old.Modules[name]
+		next, is := new.Modules[name]
+		switch {
+		case !was:
+			d.Modules = append(d.Modules, fmt.Sprintf("+ module %s (%s)", name, next.Prober))
+		case !is:
+			d.Modules = append(d.Modules, fmt.Sprintf("- module %s", name))
+		case !reflect.DeepEqual(prev, next):
+			d.Modules = append(d.Modules, fmt.Sprintf("~ module %s: %s", name, strings.Join(diffFields("", prev, next), ", ")))
+		}
+	}
+
+	for _, change := range diffFields("", old.Handlers, new.Handlers) {
+		setting := strings.TrimSuffix(strings.SplitN(change, " ", 2)[0], ":")
+		if !liveSettings[setting] {
+			change += " (takes effect after a restart)"
+		}
+		d.Settings = append(d.Settings, change)
+	}
+	return d
+}
+
+// Empty reports whether nothing changed.
+func (d ConfigDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && len(d.Modules) == 0 && len(d.Settings) == 0
+}
+
+// String describes the changes one per line, e.g.
+//
+//   - target tonto-2 (tcp tonto.cloudwalk.io:3001)
+//   - target http
+//     ~ target tcp: interval: 2 -> 5, token changed
+func (d ConfigDiff) String() string {
+	var lines []string
+	for _, t := range d.Added {
+		lines = append(lines, fmt.Sprintf("+ target %s (%s %s)", t.Name, t.Type, t.Address))
+	}
+	for _, t := range d.Removed {
+		lines = append(lines, fmt.Sprintf("- target %s", t.Name))
+	}
+	for _, c := range d.Changed {
+		lines = append(lines, fmt.Sprintf("~ target %s: %s", c.New.Name, strings.Join(c.Fields, ", ")))
+	}
+	lines = append(lines, d.Modules...)
+	for _, s := range d.Settings {
+		lines = append(lines, "~ setting "+s)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Helper function that lists the fields of two structs of the same type that
+// differ, by yaml name. Secrets are only said to have changed.
+func diffFields(prefix string, old, new interface{}) []string {
+	var out []string
+	a, b := reflect.ValueOf(old), reflect.ValueOf(new)
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			name = strings.ToLower(field.Name)
+		}
+		name = prefix + name
+		x, y := a.Field(i).Interface(), b.Field(i).Interface()
+		if reflect.DeepEqual(x, y) {
+			continue
+		}
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			out = append(out, diffFields(name+".", x, y)...)
+		case secretSettings[name]:
+			out = append(out, name+" changed")
+		default:
+			out = append(out, fmt.Sprintf("%s: %v -> %v", name, x, y))
+		}
+	}
+	return out
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WatchConfig calls reload whenever the file at path is written, created or
+// replaced, until ctx is done. The directory is watched rather than the file,
+// so a file replaced by renaming another over it is still followed.
+func WatchConfig(ctx context.Context, path string, reload func()) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		w.Close()
+		return err
+	}
+	go func() {
+		defer w.Close()
+		var pending <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-w.Events:
+				if filepath.Clean(ev.Name) != filepath.Clean(path) || ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				pending = time.After(reloadDelay)
+			case err := <-w.Errors:
+				log.Printf("config: watching %s: %v", path, err)
+			case <-pending:
+				pending = nil
+				reload()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+func TestDiffConfig(t *testing.T) {
+	old := &models.Config{
+		Targets: []models.Target{
+			{Name: "tonto", Type: "tcp", Address: "tonto.cloudwalk.io:3000", Token: "s3cret-old", Interval: 2},
+			{Name: "web", Type: "http", Address: "https://tonto-http.cloudwalk.io"},
+		},
+		Modules: map[string]models.Module{"tcp_echo": {Prober: "tcp", Timeout: 5}, "gone": {Prober: "http"}},
+	}
+	old.Handlers.Storage = "memory"
+	new := &models.Config{
+		Targets: []models.Target{
+			{Name: "tonto", Type: "tcp", Address: "tonto.cloudwalk.io:3000", Token: "s3cret-new", Interval: 5, SLO: models.SLO{Objective: 99.9}},
+			{Name: "tonto-2", Type: "tcp", Address: "tonto.cloudwalk.io:3001"},
+		},
+		Modules: map[string]models.Module{"tcp_echo": {Prober: "tcp", Timeout: 10}},
+	}
+	new.Handlers.Storage = "bolt"
+	new.Handlers.APIKey = "mg-key-123"
+
+	d := DiffConfig(old, new)
+	want := `+ target tonto-2 (tcp tonto.cloudwalk.io:3001)
+- target web
+~ target tonto: token changed, interval: 2 -> 5, slo.objective: 0 -> 99.9
+- module gone
+~ module tcp_echo: timeout: 5 -> 10
+~ setting api_key changed
+~ setting storage: memory -> bolt (takes effect after a restart)`
+	if got := d.String(); got != want {
+		t.Errorf("unexpected diff: got\n%s\nwant\n%s", got, want)
+	}
+	if strings.Contains(d.String(), "s3cret") || strings.Contains(d.String(), "mg-key") {
+		t.Errorf("secret in diff:\n%s", d)
+	}
+	if !DiffConfig(new, new).Empty() {
+		t.Errorf("unexpected diff of a configuration with itself:\n%s", DiffConfig(new, new))
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.yaml")
+	if err := ioutil.WriteFile(path, []byte("targets: []\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan struct{}, 10)
+	if err := WatchConfig(ctx, path, func() { reloads <- struct{}{} }); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(what string) {
+		t.Helper()
+		select {
+		case <-reloads:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no reload after %s", what)
+		}
+	}
+	// several writes in a row make a single reload.
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(path, []byte("targets: [] # edited\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect("write")
+	select {
+	case <-reloads:
+		t.Errorf("more than one reload for a burst of writes")
+	case <-time.After(2 * reloadDelay):
+	}
+
+	// deploy tools replace the file instead of writing it.
+	tmp := filepath.Join(dir, "app.yaml.tmp")
+	if err := ioutil.WriteFile(tmp, []byte("targets: [] # replaced\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	expect("rename")
+
+	// other files of the directory are none of our business.
+	if err := ioutil.WriteFile(filepath.Join(dir, "other.yaml"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloads:
+		t.Errorf("reload after a write to another file")
+	case <-time.After(2 * reloadDelay):
+	}
+}
@@ -0,0 +1,279 @@
+package core
+
+import (
+	"log"
+	"sync"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Number of log entries kept in memory per target unless configured otherwise.
+const DefaultLogCapacity = 500
+
+// Results keeps the recent results and status of every target for the web
+// frontend. It is safe for concurrent use: probe loops record into it while
+// handlers take snapshots. Each target has its own lock, so targets never
+// wait on each other.
+//
+// The log of each target is a ring buffer holding the most recent entries.
+// With a spill directory, entries pushed out of the ring are appended to
+// files per target instead of being dropped, and Page can reach back to them.
+// The files are capped, and those left by an earlier ring of the same target,
+// before a restart or before it was removed, are removed when it is added.
+type Results struct {
+	mu       sync.RWMutex
+	order    []string
+	targets  map[string]*targetResults
+	notify   models.Notification
+	capacity int
+	spillDir string
+}
+
+type targetResults struct {
+	mu     sync.Mutex
+	target models.Target
+	panel  models.TargetLogWarehouse // LogSlice is filled from ring on snapshot
+	ring   *logRing
+	seq    uint64 // sequence number of the last recorded entry
+	spill  *spill // nil without a spill directory
+}
+
+// NewResults returns an empty result store keeping capacity log entries per
+// target in memory, or DefaultLogCapacity if capacity is not positive. When
+// spillDir is not empty, older entries are kept in files in that directory.
+func NewResults(capacity int, spillDir string) *Results {
+	if capacity <= 0 {
+		capacity = DefaultLogCapacity
+	}
+	return &Results{
+		targets:  make(map[string]*targetResults),
+		capacity: capacity,
+		spillDir: spillDir,
+	}
+}
+
+// Add registers a target. Targets are listed in the order they were added.
+// Adding a known target again updates its configuration and leaves its
+// results alone.
+func (r *Results) Add(target models.Target) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.targets[target.Name]; ok {
+		t.mu.Lock()
+		t.target = target
+		t.panel.Type, t.panel.Address = target.Type, target.Address
+		t.mu.Unlock()
+		return
+	}
+	t := &targetResults{
+		target: target,
+		panel: models.TargetLogWarehouse{
+			Name:    target.Name,
+			Type:    target.Type,
+			Address: target.Address,
+		},
+		ring: newLogRing(r.capacity),
+	}
+	if r.spillDir != "" {
+		spill, err := newSpill(r.spillDir, target.Name)
+		if err != nil {
+			log.Printf("%s: not spilling log entries: %v", target.Name, err)
+		} else {
+			t.spill = spill
+		}
+	}
+	r.order = append(r.order, target.Name)
+	r.targets[target.Name] = t
+}
+
+// Remove forgets a target and its results, spill files included.
+func (r *Results) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.targets[name]
+	if !ok {
+		return
+	}
+	if t.spill != nil {
+		t.spill.mu.Lock()
+		if err := t.spill.remove(); err != nil {
+			log.Printf("%s: failed to remove spilled log entries: %v", name, err)
+		}
+		t.spill.mu.Unlock()
+	}
+	delete(r.targets, name)
+	for i, n := range r.order {
+		if n == name {
+			r.order = append(r.order[:i:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// Targets returns the registered targets in the order they were added.
+func (r *Results) Targets() []models.Target {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]models.Target, 0, len(r.order))
+	for _, name := range r.order {
+		out = append(out, r.targets[name].target)
+	}
+	return out
+}
+
+func (r *Results) target(name string) *targetResults {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.targets[name]
+}
+
+// Record appends a probe result and the threshold message of its health
+// check to the log of its target and returns the new entry. Results of unknown
+// targets are dropped.
+func (r *Results) Record(res models.Result, threshold string) (models.LogEntry, bool) {
+	t := r.target(res.Target)
+	if t == nil {
+		return models.LogEntry{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	t.panel.ClientLogs = res
+	entry := models.LogEntry{Seq: t.seq, Result: res, Threshold: threshold}
+	old, evicted := t.ring.push(entry)
+	if evicted && t.spill != nil {
+		if err := t.spill.write(old); err != nil {
+			log.Printf("%s: failed to spill log entry: %v", res.Target, err)
+		}
+	}
+	return entry, true
+}
+
+// Page returns up to limit log entries of the named target recorded before
+// the entry with sequence number before, oldest first. A before of zero pages
+// back from the newest entry. Entries are read from the ring buffer first and
+// from the spill files once the ring is exhausted.
+func (r *Results) Page(name string, before uint64, limit int) ([]models.LogEntry, error) {
+	t := r.target(name)
+	if t == nil || limit <= 0 {
+		return nil, nil
+	}
+	t.mu.Lock()
+	if before == 0 || before > t.seq {
+		before = t.seq + 1
+	}
+	var page []models.LogEntry
+	for _, e := range t.ring.entries() {
+		if e.Seq < before {
+			page = append(page, e)
+		}
+	}
+	t.mu.Unlock()
+
+	if len(page) >= limit {
+		return page[len(page)-limit:], nil
+	}
+	if t.spill == nil {
+		return page, nil
+	}
+	if len(page) > 0 {
+		before = page[0].Seq
+	}
+	older, err := t.spill.page(before, limit-len(page))
+	if err != nil {
+		return page, err
+	}
+	return append(older, page...), nil
+}
+
+// Older reports whether any log entry of the named target recorded before
+// the entry with sequence number before is still kept, in the ring buffer or
+// the spill files, so Page has something to return.
+func (r *Results) Older(name string, before uint64) bool {
+	t := r.target(name)
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	first, ok := t.ring.first()
+	t.mu.Unlock()
+	if ok && first.Seq < before {
+		return true
+	}
+	return t.spill != nil && t.spill.has(before)
+}
+
+// SetStatus updates the status displayed for the named target.
+func (r *Results) SetStatus(name string, status models.Status) {
+	t := r.target(name)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.panel.StatusLogs = status
+}
+
+// SetSLO replaces the SLO report of the named target. The report must not be
+// modified afterwards, snapshots share it.
+func (r *Results) SetSLO(name string, report models.SLOReport) {
+	t := r.target(name)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.panel.SLO = &report
+}
+
+// SetIncidents replaces the recent incidents of the named target, newest
+// first, and the MTTR and MTBF computed from them.
+func (r *Results) SetIncidents(name string, incs []models.Incident) {
+	t := r.target(name)
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.panel.Incidents = incs
+	t.panel.Stats = Stats(incs)
+}
+
+// SetNotification updates the subscription displayed in the frontend.
+func (r *Results) SetNotification(notify models.Notification) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notify = notify
+}
+
+// Target returns a copy of everything known about the named target.
+func (r *Results) Target(name string) (models.TargetLogWarehouse, bool) {
+	t := r.target(name)
+	if t == nil {
+		return models.TargetLogWarehouse{}, false
+	}
+	return t.snapshot(), true
+}
+
+// Snapshot returns a copy of the whole store that stays consistent for as
+// long as the caller needs it, however much is recorded in the meantime.
+func (r *Results) Snapshot() models.LogWarehouse {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	w := models.LogWarehouse{
+		Notification: r.notify,
+		Targets:      make([]models.TargetLogWarehouse, 0, len(r.order)),
+	}
+	for _, name := range r.order {
+		w.Targets = append(w.Targets, r.targets[name].snapshot())
+	}
+	return w
+}
+
+func (t *targetResults) snapshot() models.TargetLogWarehouse {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	panel := t.panel
+	panel.LogSlice = t.ring.entries()
+	return panel
+}
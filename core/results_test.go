@@ -0,0 +1,195 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+func TestResultsSnapshotIsolation(t *testing.T) {
+	r := NewResults(0, "")
+	r.Add(models.Target{Name: "tonto", Type: "tcp"})
+	r.Record(models.Result{Target: "tonto", Success: true}, "")
+
+	snap := r.Snapshot()
+	r.Record(models.Result{Target: "tonto"}, "Failure Threshold Reached.")
+	r.SetStatus("tonto", models.Status{State: "unhealthy"})
+
+	if got := len(snap.Targets[0].LogSlice); got != 1 {
+		t.Errorf("snapshot changed after the fact: got (%d) entries want (1)", got)
+	}
+	if snap.Targets[0].StatusLogs.State != "" {
+		t.Errorf("snapshot changed after the fact: got (%q) state", snap.Targets[0].StatusLogs.State)
+	}
+	now, _ := r.Target("tonto")
+	if len(now.LogSlice) != 2 || now.LogSlice[1].Threshold == "" || now.StatusLogs.State != "unhealthy" {
+		t.Errorf("unexpected target: %+v", now)
+	}
+	if _, ok := r.Target("nobody"); ok {
+		t.Error("unexpected unknown target")
+	}
+}
+
+func TestResultsRing(t *testing.T) {
+	r := NewResults(3, "")
+	r.Add(models.Target{Name: "tonto"})
+	for i := 0; i < 5; i++ {
+		r.Record(models.Result{Target: "tonto"}, "")
+	}
+	got, _ := r.Target("tonto")
+	if seqs := seqsOf(got.LogSlice); seqs != "3 4 5" {
+		t.Errorf("unexpected log: got (%s) want (3 4 5)", seqs)
+	}
+	// without a spill directory, evicted entries are gone.
+	page, err := r.Page("tonto", 4, 10)
+	if err != nil || seqsOf(page) != "3" {
+		t.Errorf("unexpected page: got (%s, %v) want (3)", seqsOf(page), err)
+	}
+	if got := fmt.Sprint(r.Older("tonto", 3), r.Older("tonto", 4)); got != "false true" {
+		t.Errorf("unexpected older: got (%s) want (false true)", got)
+	}
+}
+
+func TestResultsPageWithSpill(t *testing.T) {
+	r := NewResults(4, t.TempDir())
+	r.Add(models.Target{Name: "tonto/http"})
+	for i := 0; i < 10; i++ {
+		r.Record(models.Result{Target: "tonto/http"}, "")
+	}
+
+	tests := []struct {
+		before uint64
+		limit  int
+		want   string
+	}{
+		{0, 3, "8 9 10"},
+		{0, 6, "5 6 7 8 9 10"},
+		{8, 3, "5 6 7"},
+		{5, 10, "1 2 3 4"},
+		{1, 10, ""},
+	}
+	for _, tt := range tests {
+		page, err := r.Page("tonto/http", tt.before, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := seqsOf(page); got != tt.want {
+			t.Errorf("Page(%d, %d): got (%s) want (%s)", tt.before, tt.limit, got, tt.want)
+		}
+	}
+	if got := fmt.Sprint(r.Older("tonto/http", 7), r.Older("tonto/http", 2), r.Older("tonto/http", 1)); got != "true true false" {
+		t.Errorf("unexpected older: got (%s) want (true true false)", got)
+	}
+}
+
+func TestResultsSpillStartsOver(t *testing.T) {
+	dir := t.TempDir()
+	first := NewResults(2, dir)
+	first.Add(models.Target{Name: "tonto"})
+	for i := 0; i < 6; i++ {
+		first.Record(models.Result{Target: "tonto"}, "")
+	}
+
+	// a restart starts the sequence over with the same spill file.
+	r := NewResults(2, dir)
+	r.Add(models.Target{Name: "tonto"})
+	for i := 0; i < 4; i++ {
+		r.Record(models.Result{Target: "tonto"}, "")
+	}
+	if page, err := r.Page("tonto", 0, 10); err != nil || seqsOf(page) != "1 2 3 4" {
+		t.Errorf("unexpected page after restart: got (%s, %v) want (1 2 3 4)", seqsOf(page), err)
+	}
+
+	// and so does removing the target and adding it back.
+	r.Remove("tonto")
+	if _, err := os.Stat(filepath.Join(dir, "tonto.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("unexpected spill file after Remove: got (%v) want (not exist)", err)
+	}
+	r.Add(models.Target{Name: "tonto"})
+	for i := 0; i < 3; i++ {
+		r.Record(models.Result{Target: "tonto"}, "")
+	}
+	if page, err := r.Page("tonto", 0, 10); err != nil || seqsOf(page) != "1 2 3" {
+		t.Errorf("unexpected page after Remove: got (%s, %v) want (1 2 3)", seqsOf(page), err)
+	}
+}
+
+func TestResultsSpillRotates(t *testing.T) {
+	dir := t.TempDir()
+	r := NewResults(2, dir)
+	r.Add(models.Target{Name: "tonto"})
+	r.targets["tonto"].spill.limit = 3
+	for i := 0; i < 12; i++ {
+		r.Record(models.Result{Target: "tonto"}, "")
+	}
+
+	// 10 entries were spilled, in files of 1-3, 4-6, 7-9 and 10. Only the
+	// last two are kept.
+	page, err := r.Page("tonto", 0, 20)
+	if err != nil || seqsOf(page) != "7 8 9 10 11 12" {
+		t.Errorf("unexpected page: got (%s, %v) want (7 8 9 10 11 12)", seqsOf(page), err)
+	}
+	page, err = r.Page("tonto", 11, 3)
+	if err != nil || seqsOf(page) != "8 9 10" {
+		t.Errorf("unexpected page: got (%s, %v) want (8 9 10)", seqsOf(page), err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 2 {
+		t.Errorf("unexpected spill files: got (%v) want (2)", files)
+	}
+}
+
+func seqsOf(entries []models.LogEntry) string {
+	s := ""
+	for i, e := range entries {
+		if i > 0 {
+			s += " "
+		}
+		s += fmt.Sprint(e.Seq)
+	}
+	return s
+}
+
+// Run with -race: several targets record while readers take snapshots.
+func TestResultsConcurrent(t *testing.T) {
+	r := NewResults(0, "")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("target-%d", i)
+		r.Add(models.Target{Name: name})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				r.Record(models.Result{Target: name, Start: time.Now()}, "")
+				r.SetStatus(name, models.Status{State: "healthy", Uptime: j})
+				r.SetNotification(models.Notification{Update: j%2 == 0})
+			}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				for _, panel := range r.Snapshot().Targets {
+					for _, entry := range panel.LogSlice {
+						_ = entry.Start
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, panel := range r.Snapshot().Targets {
+		if len(panel.LogSlice) != 200 {
+			t.Errorf("%s: got (%d) entries want (200)", panel.Name, len(panel.LogSlice))
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package core
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Default retention when the configuration does not set one.
+const (
+	defaultRawRetention    = 48 * time.Hour
+	defaultMinuteRetention = 14 * day
+	defaultHourRetention   = 400 * day
+)
+
+// A minute or an hour is only rolled up this long after it ends, to leave
+// time for the results of the probes started in it to be stored.
+const rollupDelay = 2 * time.Minute
+
+// Retention rolls the probe history of every target up into per-minute and
+// per-hour aggregates and prunes raw results and rollups once they are older
+// than their retention. Raw results are never pruned before they are rolled
+// up into hours.
+type Retention struct {
+	Store  Store
+	Raw    time.Duration // How long raw results are kept
+	Minute time.Duration // How long per-minute rollups are kept
+	Hour   time.Duration // How long per-hour rollups are kept
+
+	mu    sync.Mutex
+	marks map[string]time.Time // end of the rollups of each target and resolution
+}
+
+// NewRetention returns the retention configured in c. Raw results are kept for
+// at least two hours, so that every hour can be rolled up.
+func NewRetention(store Store, c *models.Config) *Retention {
+	r := &Retention{
+		Store:  store,
+		Raw:    time.Duration(c.Handlers.RawRetention) * time.Hour,
+		Minute: time.Duration(c.Handlers.MinuteRetention) * day,
+		Hour:   time.Duration(c.Handlers.HourRetention) * day,
+	}
+	if r.Raw <= 0 {
+		r.Raw = defaultRawRetention
+	}
+	if r.Raw < 2*time.Hour {
+		r.Raw = 2 * time.Hour
+	}
+	if r.Minute <= 0 {
+		r.Minute = defaultMinuteRetention
+	}
+	if r.Hour <= 0 {
+		r.Hour = defaultHourRetention
+	}
+	return r
+}
+
+// Run applies the retention to the targets returned by targets every t until ctx
+// is done. It runs on its own and never holds up the probe loops.
+func (r *Retention) Run(ctx context.Context, targets func() []string, t time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(t):
+		}
+		for _, name := range targets() {
+			if err := r.Apply(ctx, name, time.Now()); err != nil {
+				log.Printf("%s: retention: %v", name, err)
+			}
+		}
+	}
+}
+
+// Apply rolls up the minutes and hours of target that ended before now and
+// prunes what is past its retention.
+func (r *Retention) Apply(ctx context.Context, target string, now time.Time) error {
+	if err := r.rollup(ctx, target, time.Minute, now); err != nil {
+		return err
+	}
+	if err := r.rollup(ctx, target, time.Hour, now); err != nil {
+		return err
+	}
+
+	// raw results are still needed for the hours not rolled up yet.
+	before := now.Add(-r.Raw)
+	if mark := r.mark(target, time.Hour); mark.Before(before) {
+		before = mark
+	}
+	n, err := r.Store.PruneResults(ctx, target, before)
+	if err != nil {
+		return err
+	}
+	m, err := r.Store.PruneRollups(ctx, target, time.Minute, now.Add(-r.Minute))
+	if err != nil {
+		return err
+	}
+	h, err := r.Store.PruneRollups(ctx, target, time.Hour, now.Add(-r.Hour))
+	if err != nil {
+		return err
+	}
+	if n+m+h > 0 {
+		log.Printf("%s: pruned %d results, %d minute and %d hour rollups", target, n, m, h)
+	}
+	return nil
+}
+
+func (r *Retention) mark(target string, resolution time.Duration) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.marks[rollupKey(target, resolution)]
+}
+
+func (r *Retention) setMark(target string, resolution time.Duration, mark time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.marks == nil {
+		r.marks = map[string]time.Time{}
+	}
+	r.marks[rollupKey(target, resolution)] = mark
+}
+
+// Helper function that rolls up every period of target at resolution between
+// the end of the previous rollups and now, an hour of results at a time.
+func (r *Retention) rollup(ctx context.Context, target string, resolution time.Duration, now time.Time) error {
+	end := now.Add(-rollupDelay).Truncate(resolution)
+	mark := r.mark(target, resolution)
+	if mark.IsZero() {
+		var err error
+		if mark, err = r.resume(ctx, target, resolution, end); err != nil || mark.IsZero() {
+			return err
+		}
+	}
+
+	for mark.Before(end) {
+		next := mark.Add(time.Hour)
+		if next.After(end) {
+			next = end
+		}
+		results, err := r.Store.Results(ctx, target, mark, next, 0)
+		if err != nil {
+			return err
+		}
+		if err := r.Store.SaveRollups(ctx, Aggregate(results, resolution)); err != nil {
+			return err
+		}
+		mark = next
+		r.setMark(target, resolution, mark)
+	}
+	return nil
+}
+
+// Helper function finding where the rollups of target at resolution left off:
+// after the latest stored rollup or, when there are none, at the oldest raw
+// result. It returns the zero time when there is nothing to roll up.
+func (r *Retention) resume(ctx context.Context, target string, resolution time.Duration, end time.Time) (time.Time, error) {
+	rollups, err := r.Store.Rollups(ctx, target, resolution, end.Add(-r.Raw), end)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(rollups) > 0 {
+		return rollups[len(rollups)-1].Start.Add(resolution), nil
+	}
+	oldest, err := r.Store.Results(ctx, target, time.Time{}, end, 1)
+	if err != nil || len(oldest) == 0 {
+		return time.Time{}, err
+	}
+	return oldest[0].Start.Truncate(resolution), nil
+}
+
+// Aggregate rolls results up into one rollup per period of resolution that
+// has results, oldest first.
+func Aggregate(results []models.Result, resolution time.Duration) []models.Rollup {
+	periods := map[time.Time][]models.Result{}
+	for _, res := range results {
+		start := res.Start.Truncate(resolution)
+		periods[start] = append(periods[start], res)
+	}
+
+	out := make([]models.Rollup, 0, len(periods))
+	for start, rs := range periods {
+		r := models.Rollup{Target: rs[0].Target, Resolution: resolution, Start: start, Count: len(rs)}
+		latency := make([]time.Duration, 0, len(rs))
+		var sum time.Duration
+		for _, res := range rs {
+			if !res.Success {
+				r.Failures++
+			}
+			latency = append(latency, res.Duration)
+			sum += res.Duration
+		}
+		sort.Slice(latency, func(i, j int) bool { return latency[i] < latency[j] })
+		r.Min = latency[0]
+		r.Max = latency[len(latency)-1]
+		r.Avg = sum / time.Duration(len(latency))
+		r.P95 = latency[(len(latency)*95+99)/100-1] // nearest rank
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
+
+// Resolutions of the rollups probes are counted from, coarsest first.
+var countResolutions = []time.Duration{time.Hour, time.Minute}
+
+// countProbes counts the probes of target in [from, to) and how many failed.
+// Raw results are pruned after a while and costly to count, so whole hours are
+// counted from their rollups when there are any, whole minutes of what is left
+// from theirs, and only the rest from raw results.
+func countProbes(ctx context.Context, store Store, target string, from time.Time, to time.Time) (int, int, error) {
+	return newProbeCounter(store, target).count(ctx, countResolutions, from, to)
+}
+
+// probeCounter counts the probes of a target over several windows, such as
+// those of an SLO report. Rollups fetched ahead of time with prefetch and raw
+// counts are shared between the windows, so overlapping windows cost little
+// more than the widest of them.
+type probeCounter struct {
+	store   Store
+	target  string
+	fetched map[time.Duration]rollupSpan
+	raw     map[[2]time.Time][2]int // Raw counts by [from, to)
+}
+
+// rollupSpan holds the rollups that start in [from, to).
+type rollupSpan struct {
+	from, to time.Time
+	rollups  []models.Rollup
+}
+
+func newProbeCounter(store Store, target string) *probeCounter {
+	return &probeCounter{
+		store:   store,
+		target:  target,
+		fetched: map[time.Duration]rollupSpan{},
+		raw:     map[[2]time.Time][2]int{},
+	}
+}
+
+// prefetch fetches the rollups at resolution that start in [from, to), to
+// count the windows within it from.
+func (c *probeCounter) prefetch(ctx context.Context, resolution time.Duration, from time.Time, to time.Time) error {
+	rollups, err := c.store.Rollups(ctx, c.target, resolution, from, to)
+	if err != nil {
+		return err
+	}
+	c.fetched[resolution] = rollupSpan{from: from, to: to, rollups: rollups}
+	return nil
+}
+
+func (c *probeCounter) rollups(ctx context.Context, resolution time.Duration, from time.Time, to time.Time) ([]models.Rollup, error) {
+	span, ok := c.fetched[resolution]
+	if !ok || from.Before(span.from) || to.After(span.to) {
+		return c.store.Rollups(ctx, c.target, resolution, from, to)
+	}
+	rs := span.rollups
+	lo := sort.Search(len(rs), func(i int) bool { return !rs[i].Start.Before(from) })
+	hi := sort.Search(len(rs), func(i int) bool { return !rs[i].Start.Before(to) })
+	return rs[lo:hi], nil
+}
+
+func (c *probeCounter) counts(ctx context.Context, from time.Time, to time.Time) (int, int, error) {
+	key := [2]time.Time{from, to}
+	if n, ok := c.raw[key]; ok {
+		return n[0], n[1], nil
+	}
+	total, failures, err := c.store.Counts(ctx, c.target, from, to)
+	if err != nil {
+		return 0, 0, err
+	}
+	c.raw[key] = [2]int{total, failures}
+	return total, failures, nil
+}
+
+// count counts the probes in [from, to) from the rollups at the first of
+// resolutions, and the edges they leave from the next ones.
+func (c *probeCounter) count(ctx context.Context, resolutions []time.Duration, from time.Time, to time.Time) (int, int, error) {
+	if !from.Before(to) {
+		return 0, 0, nil
+	}
+	if len(resolutions) == 0 {
+		return c.counts(ctx, from, to)
+	}
+	res := resolutions[0]
+	first := from.Truncate(res)
+	if first.Before(from) {
+		first = first.Add(res)
+	}
+	var rollups []models.Rollup
+	if last := to.Truncate(res); first.Before(last) {
+		var err error
+		if rollups, err = c.rollups(ctx, res, first, last); err != nil {
+			return 0, 0, err
+		}
+	}
+	if len(rollups) == 0 {
+		return c.count(ctx, resolutions[1:], from, to)
+	}
+
+	total, failures := 0, 0
+	for _, r := range rollups {
+		total += r.Count
+		failures += r.Failures
+	}
+	edges := [][2]time.Time{
+		{from, rollups[0].Start},
+		{rollups[len(rollups)-1].Start.Add(res), to},
+	}
+	for _, e := range edges {
+		t, f, err := c.count(ctx, resolutions[1:], e[0], e[1])
+		if err != nil {
+			return 0, 0, err
+		}
+		total += t
+		failures += f
+	}
+	return total, failures, nil
+}
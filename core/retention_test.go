@@ -0,0 +1,114 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+func TestAggregate(t *testing.T) {
+	base := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	var results []models.Result
+	// minute 0: 20 probes taking 1..20ms, every fifth one failing. minute 1: one probe.
+	for i := 1; i <= 20; i++ {
+		results = append(results, models.Result{Target: "tonto", Start: base.Add(time.Duration(i) * time.Second), Duration: time.Duration(i) * time.Millisecond, Success: i%5 != 0})
+	}
+	results = append(results, models.Result{Target: "tonto", Start: base.Add(90 * time.Second), Duration: 7 * time.Millisecond, Success: true})
+
+	got := Aggregate(results, time.Minute)
+	want := []models.Rollup{
+		{Target: "tonto", Resolution: time.Minute, Start: base, Count: 20, Failures: 4,
+			Min: time.Millisecond, Avg: 10500 * time.Microsecond, P95: 19 * time.Millisecond, Max: 20 * time.Millisecond},
+		{Target: "tonto", Resolution: time.Minute, Start: base.Add(time.Minute), Count: 1,
+			Min: 7 * time.Millisecond, Avg: 7 * time.Millisecond, P95: 7 * time.Millisecond, Max: 7 * time.Millisecond},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected rollups: got (%+v) want (%+v)", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("unexpected rollup: got (%+v) want (%+v)", got[i], want[i])
+		}
+	}
+	if hours := Aggregate(results, time.Hour); len(hours) != 1 || hours[0].Count != 21 || hours[0].Failures != 4 {
+		t.Errorf("unexpected hour rollups: %+v", hours)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	for name, store := range stores(t) {
+		// one probe every 10 seconds for 5 hours, failing in the second hour.
+		for s := 0; s < 5*3600; s += 10 {
+			at := base.Add(time.Duration(s) * time.Second)
+			res := models.Result{Target: "tonto", Start: at, Duration: time.Millisecond, Success: at.Hour() != 1}
+			if err := store.AddResult(ctx, res); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		r := NewRetention(store, &models.Config{})
+		r.Raw = 2 * time.Hour
+		now := base.Add(5*time.Hour + rollupDelay)
+		if err := r.Apply(ctx, "tonto", now); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		minutes, err := store.Rollups(ctx, "tonto", time.Minute, base, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hours, err := store.Rollups(ctx, "tonto", time.Hour, base, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(minutes) != 5*60 || len(hours) != 5 {
+			t.Errorf("%s: unexpected rollups: got (%d, %d) want (%d, %d)", name, len(minutes), len(hours), 5*60, 5)
+		}
+		if len(hours) > 1 && (hours[1].Count != 360 || hours[1].Failures != 360 || hours[0].Failures != 0) {
+			t.Errorf("%s: unexpected hour rollups: %+v", name, hours[:2])
+		}
+
+		raw, err := store.Results(ctx, "tonto", time.Time{}, now, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(raw) == 0 || raw[0].Start.Before(now.Add(-r.Raw)) {
+			t.Errorf("%s: raw results were not pruned: %d left", name, len(raw))
+		}
+
+		// the whole history is still counted, from rollups where raw results are gone.
+		total, failures, err := countProbes(ctx, store, "tonto", base, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 5*360 || failures != 360 {
+			t.Errorf("%s: unexpected counts: got (%d, %d) want (%d, %d)", name, total, failures, 5*360, 360)
+		}
+		// and the partial hours at the edges from minute rollups.
+		total, failures, err = countProbes(ctx, store, "tonto", base.Add(30*time.Minute), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 9*180 || failures != 360 {
+			t.Errorf("%s: unexpected counts: got (%d, %d) want (%d, %d)", name, total, failures, 9*180, 360)
+		}
+
+		// nothing is rolled up twice, and old rollups are pruned in turn.
+		r.Minute = time.Hour
+		if err := r.Apply(ctx, "tonto", now.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		minutes, err = store.Rollups(ctx, "tonto", time.Minute, base, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(minutes) != 57 {
+			t.Errorf("%s: unexpected minute rollups after pruning: got (%d) want (%d)", name, len(minutes), 57)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package core
+
+import "github.com/icommit/SRETest/pkg/models"
+
+// logRing is a bounded ring buffer of log entries. Once full, every push
+// evicts the oldest entry and hands it back to the caller.
+type logRing struct {
+	buf   []models.LogEntry
+	start int // index of the oldest entry
+	n     int // number of entries held
+}
+
+func newLogRing(capacity int) *logRing {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &logRing{buf: make([]models.LogEntry, capacity)}
+}
+
+// push appends e and returns the evicted entry, if any.
+func (r *logRing) push(e models.LogEntry) (models.LogEntry, bool) {
+	if r.n < len(r.buf) {
+		r.buf[(r.start+r.n)%len(r.buf)] = e
+		r.n++
+		return models.LogEntry{}, false
+	}
+	old := r.buf[r.start]
+	r.buf[r.start] = e
+	r.start = (r.start + 1) % len(r.buf)
+	return old, true
+}
+
+// first returns the oldest entry held, if any.
+func (r *logRing) first() (models.LogEntry, bool) {
+	return r.buf[r.start], r.n > 0
+}
+
+// entries returns a copy of the held entries, oldest first.
+func (r *logRing) entries() []models.LogEntry {
+	out := make([]models.LogEntry, r.n)
+	for i := 0; i < r.n; i++ {
+		out[i] = r.buf[(r.start+i)%len(r.buf)]
+	}
+	return out
+}
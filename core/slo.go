@@ -0,0 +1,195 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Window of an objective that does not name one.
+const defaultSLOWindowDays = 30
+
+const day = 24 * time.Hour
+
+// Rolling windows the availability of every target is reported over.
+var reportWindows = []time.Duration{day, 7 * day, 30 * day}
+
+// Multi-window burn-rate alerts. An alert fires when both its long and its
+// short window burn the error budget faster than the threshold: the long window
+// makes sure enough budget is gone to matter, the short one that it is still
+// going. The thresholds spend 2% of a 30 day budget in an hour and 5% in six.
+var burnRules = []struct {
+	name        string
+	long, short time.Duration
+	threshold   float64
+}{
+	{"fast", time.Hour, 5 * time.Minute, 14.4},
+	{"slow", 6 * time.Hour, 30 * time.Minute, 6},
+}
+
+// Helper function that counts the probes in the window ending at now.
+func sloWindow(ctx context.Context, c *probeCounter, window time.Duration, now time.Time) (models.SLOWindow, error) {
+	total, failures, err := c.count(ctx, countResolutions, now.Add(-window), now)
+	if err != nil {
+		return models.SLOWindow{}, err
+	}
+	w := models.SLOWindow{Window: window, Total: total, Failures: failures, Availability: 100}
+	if total > 0 {
+		w.Availability = 100 * float64(total-failures) / float64(total)
+	}
+	return w, nil
+}
+
+// Helper function returning the fraction of probes the objective allows to fail.
+// An objective of 100% would make every burn rate infinite, so it is capped.
+func errorBudget(slo models.SLO) float64 {
+	budget := 1 - slo.Objective/100
+	if budget < 1e-6 {
+		budget = 1e-6
+	}
+	return budget
+}
+
+// Helper function returning how many times faster than allowed w spends the budget.
+func burnRate(w models.SLOWindow, budget float64) float64 {
+	if w.Total == 0 {
+		return 0
+	}
+	return float64(w.Failures) / float64(w.Total) / budget
+}
+
+// ReportSLO computes the availability of target from the probe history: over
+// the last 1, 7 and 30 days, over the window of its objective along with the
+// error budget left, and the burn rate of every alert. It runs every minute,
+// so the hour rollups of the widest window and the minute rollups of the last
+// two hours are fetched once and every window is counted from them, leaving
+// only its edges to the store.
+func ReportSLO(ctx context.Context, store Store, target models.Target, now time.Time) (models.SLOReport, error) {
+	slo := target.SLO
+	if slo.WindowDays <= 0 {
+		slo.WindowDays = defaultSLOWindowDays
+	}
+	report := models.SLOReport{Target: target.Name, SLO: slo, At: now}
+	budgetWindow := time.Duration(slo.WindowDays) * day
+	widest := budgetWindow
+	for _, window := range reportWindows {
+		if window > widest {
+			widest = window
+		}
+	}
+	c := newProbeCounter(store, target.Name)
+	if err := c.prefetch(ctx, time.Hour, now.Add(-widest), now); err != nil {
+		return report, err
+	}
+	if err := c.prefetch(ctx, time.Minute, now.Truncate(time.Hour).Add(-time.Hour), now); err != nil {
+		return report, err
+	}
+
+	for _, window := range reportWindows {
+		w, err := sloWindow(ctx, c, window, now)
+		if err != nil {
+			return report, err
+		}
+		report.Windows = append(report.Windows, w)
+		if window == budgetWindow {
+			report.Budget = w
+		}
+	}
+
+	budget := errorBudget(slo)
+	if report.Budget.Window == 0 {
+		var err error
+		if report.Budget, err = sloWindow(ctx, c, budgetWindow, now); err != nil {
+			return report, err
+		}
+	}
+	report.BudgetRemaining = 1 - burnRate(report.Budget, budget)
+
+	for _, rule := range burnRules {
+		long, err := sloWindow(ctx, c, rule.long, now)
+		if err != nil {
+			return report, err
+		}
+		short, err := sloWindow(ctx, c, rule.short, now)
+		if err != nil {
+			return report, err
+		}
+		b := models.BurnRate{
+			Alert:     rule.name,
+			Long:      rule.long,
+			Short:     rule.short,
+			Threshold: rule.threshold,
+			LongRate:  burnRate(long, budget),
+			ShortRate: burnRate(short, budget),
+		}
+		b.Firing = b.LongRate >= b.Threshold && b.ShortRate >= b.Threshold
+		report.Burn = append(report.Burn, b)
+	}
+	return report, nil
+}
+
+// SLOMonitor reports the SLO of targets and notifies the subscriber when a
+// burn-rate alert starts or stops firing.
+type SLOMonitor struct {
+	Store Store
+
+	mu     sync.Mutex
+	firing map[string]bool // target/alert pairs currently firing
+}
+
+// Check computes the SLO report of target and sends a notification for every
+// burn-rate alert that changed since the previous check.
+func (m *SLOMonitor) Check(ctx context.Context, target models.Target, now time.Time) (models.SLOReport, error) {
+	report, err := ReportSLO(ctx, m.Store, target, now)
+	if err != nil {
+		return report, err
+	}
+
+	m.mu.Lock()
+	if m.firing == nil {
+		m.firing = map[string]bool{}
+	}
+	var changed []models.BurnRate
+	for _, b := range report.Burn {
+		key := target.Name + "/" + b.Alert
+		if m.firing[key] != b.Firing {
+			m.firing[key] = b.Firing
+			changed = append(changed, b)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, b := range changed {
+		subject, body := burnMessage(report, b)
+		log.Printf("%s: %s", target.Name, subject)
+		notify, err := m.Store.GetNotification(ctx)
+		if err != nil {
+			log.Printf("failed to read notification: %v", err)
+			continue
+		}
+		notifySubscriber(ctx, NotifyBurnRate, notify, subject, body)
+	}
+	return report, nil
+}
+
+// Helper function that writes the notification for a burn-rate alert.
+func burnMessage(report models.SLOReport, b models.BurnRate) (subject, body string) {
+	if b.Firing {
+		subject = fmt.Sprintf("%s is burning its error budget (%s)", report.Target, b.Alert)
+	} else {
+		subject = fmt.Sprintf("%s error budget burn resolved (%s)", report.Target, b.Alert)
+	}
+	body = fmt.Sprintf("Objective: %g%% over %d days\n"+
+		"Burn rate over %s: %.1f (alert at %g)\n"+
+		"Burn rate over %s: %.1f (alert at %g)\n"+
+		"Error budget remaining: %.1f%%",
+		report.SLO.Objective, report.SLO.WindowDays,
+		b.Long, b.LongRate, b.Threshold,
+		b.Short, b.ShortRate, b.Threshold,
+		100*report.BudgetRemaining)
+	return subject, body
+}
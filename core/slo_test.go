@@ -0,0 +1,154 @@
+package core
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Helper function that fills a store with 30 days of probes, one every five
+// minutes, of which the last hour failed.
+func sloHistory(t *testing.T, now time.Time) *MemoryStore {
+	store := NewMemoryStore()
+	for k := 1; k <= 30*24*12; k++ {
+		res := models.Result{Target: "tonto", Start: now.Add(-time.Duration(k) * 5 * time.Minute), Success: k > 12}
+		if err := store.AddResult(context.Background(), res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestReportSLO(t *testing.T) {
+	now := time.Date(2021, 9, 30, 12, 0, 0, 0, time.UTC)
+	store := sloHistory(t, now)
+	target := models.Target{Name: "tonto", SLO: models.SLO{Objective: 99}}
+
+	report, err := ReportSLO(context.Background(), store, target, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SLO.WindowDays != defaultSLOWindowDays {
+		t.Errorf("unexpected window: got (%v) want (%v)", report.SLO.WindowDays, defaultSLOWindowDays)
+	}
+
+	want := []models.SLOWindow{
+		{Window: day, Total: 288, Failures: 12, Availability: 100 * 276.0 / 288},
+		{Window: 7 * day, Total: 2016, Failures: 12, Availability: 100 * 2004.0 / 2016},
+		{Window: 30 * day, Total: 8640, Failures: 12, Availability: 100 * 8628.0 / 8640},
+	}
+	if len(report.Windows) != len(want) {
+		t.Fatalf("unexpected windows: got (%v) want (%v)", report.Windows, want)
+	}
+	for i, w := range want {
+		if report.Windows[i] != w {
+			t.Errorf("unexpected window: got (%+v) want (%+v)", report.Windows[i], w)
+		}
+	}
+	if report.Budget != want[2] {
+		t.Errorf("unexpected budget window: got (%+v) want (%+v)", report.Budget, want[2])
+	}
+	if remaining := 1 - 12.0/8640/0.01; math.Abs(report.BudgetRemaining-remaining) > 1e-9 {
+		t.Errorf("unexpected budget remaining: got (%v) want (%v)", report.BudgetRemaining, remaining)
+	}
+
+	rates := map[string][2]float64{"fast": {100, 100}, "slow": {12.0 / 72 / 0.01, 100}}
+	for _, b := range report.Burn {
+		want := rates[b.Alert]
+		if math.Abs(b.LongRate-want[0]) > 1e-9 || math.Abs(b.ShortRate-want[1]) > 1e-9 {
+			t.Errorf("unexpected %s burn rate: got (%v, %v) want (%v, %v)", b.Alert, b.LongRate, b.ShortRate, want[0], want[1])
+		}
+		if !b.Firing {
+			t.Errorf("unexpected %s alert: got (%v) want (%v)", b.Alert, b.Firing, true)
+		}
+	}
+}
+
+// countingStore counts the queries that read the probe history.
+type countingStore struct {
+	Store
+	counts, rollups int
+}
+
+func (s *countingStore) Counts(ctx context.Context, target string, from time.Time, to time.Time) (int, int, error) {
+	s.counts++
+	return s.Store.Counts(ctx, target, from, to)
+}
+
+func (s *countingStore) Rollups(ctx context.Context, target string, resolution time.Duration, from time.Time, to time.Time) ([]models.Rollup, error) {
+	s.rollups++
+	return s.Store.Rollups(ctx, target, resolution, from, to)
+}
+
+// Once the history is rolled up, a report reads the rollups of its widest
+// window once and raw results only for the minutes not rolled up yet.
+func TestReportSLOFromRollups(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 30, 12, 0, 0, 0, time.UTC)
+	store := &countingStore{Store: sloHistory(t, now)}
+	if err := NewRetention(store, &models.Config{}).Apply(ctx, "tonto", now); err != nil {
+		t.Fatal(err)
+	}
+	store.counts, store.rollups = 0, 0
+
+	target := models.Target{Name: "tonto", SLO: models.SLO{Objective: 99}}
+	report, err := ReportSLO(ctx, store, target, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{288, 2016, 8640}
+	for i, w := range report.Windows {
+		if w.Total != want[i] || w.Failures != 12 {
+			t.Errorf("unexpected %v window: got (%d, %d) want (%d, %d)", w.Window, w.Total, w.Failures, want[i], 12)
+		}
+	}
+	if report.Budget != report.Windows[2] {
+		t.Errorf("unexpected budget window: got (%+v) want (%+v)", report.Budget, report.Windows[2])
+	}
+	if store.rollups != 2 || store.counts != 1 {
+		t.Errorf("unexpected queries: got (%d, %d) want (%d, %d)", store.rollups, store.counts, 2, 1)
+	}
+}
+
+func TestReportSLONoHistory(t *testing.T) {
+	target := models.Target{Name: "tonto", SLO: models.SLO{Objective: 99.9, WindowDays: 7}}
+	report, err := ReportSLO(context.Background(), NewMemoryStore(), target, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Budget.Availability != 100 || report.BudgetRemaining != 1 {
+		t.Errorf("unexpected budget: got (%v, %v) want (%v, %v)", report.Budget.Availability, report.BudgetRemaining, 100, 1)
+	}
+	for _, b := range report.Burn {
+		if b.Firing {
+			t.Errorf("unexpected %s alert: got (%v) want (%v)", b.Alert, b.Firing, false)
+		}
+	}
+}
+
+func TestSLOMonitor(t *testing.T) {
+	now := time.Date(2021, 9, 30, 12, 0, 0, 0, time.UTC)
+	m := &SLOMonitor{Store: sloHistory(t, now)}
+	target := models.Target{Name: "tonto", SLO: models.SLO{Objective: 99}}
+
+	tests := []struct {
+		at   time.Time
+		want bool
+	}{
+		{now, true},
+		{now.Add(7 * time.Hour), false},
+	}
+	for _, tt := range tests {
+		if _, err := m.Check(context.Background(), target, tt.at); err != nil {
+			t.Fatal(err)
+		}
+		for _, alert := range []string{"fast", "slow"} {
+			if got := m.firing["tonto/"+alert]; got != tt.want {
+				t.Errorf("unexpected %s alert at %v: got (%v) want (%v)", alert, tt.at, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Entries per spill file. A target keeps two files, the one being written and
+// the one before it, so at most twice as many entries are spilled: about 22
+// hours of history at a 2 second interval.
+const spillSegment = 20000
+
+// spill keeps the log entries evicted from a target's ring buffer in json
+// lines files on local disk, so they can still be paged through. The file
+// being written is <name>.jsonl and the one before it <name>.jsonl.1. The
+// offset of every entry is kept in memory, so a page is read straight from
+// where it starts.
+//
+// Sequence numbers start over with every ring buffer, so the files of an
+// earlier ring are removed when the spill is created.
+type spill struct {
+	mu    sync.Mutex
+	path  string
+	limit int        // Entries per file
+	segs  [2]segment // The previous file and the current one
+}
+
+// segment indexes the entries of a spill file. Spilled entries have
+// consecutive sequence numbers, so the entry with sequence number first+i
+// starts at offsets[i].
+type segment struct {
+	first   uint64
+	offsets []int64
+	size    int64
+}
+
+func newSpill(dir string, name string) (*spill, error) {
+	s := &spill{path: filepath.Join(dir, url.PathEscape(name)+".jsonl"), limit: spillSegment}
+	return s, s.remove()
+}
+
+// remove deletes the files of the spill and forgets their entries.
+func (s *spill) remove() error {
+	s.segs = [2]segment{}
+	for _, path := range []string{s.path, s.path + ".1"} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *spill) write(e models.LogEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur := &s.segs[1]
+	if n := len(cur.offsets); n > 0 && (n >= s.limit || e.Seq != cur.first+uint64(n)) {
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return err
+		}
+		s.segs[0], s.segs[1] = *cur, segment{}
+	}
+
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(buf, '\n')); err != nil {
+		return err
+	}
+	if len(cur.offsets) == 0 {
+		cur.first = e.Seq
+	}
+	cur.offsets = append(cur.offsets, cur.size)
+	cur.size += int64(len(buf) + 1)
+	return nil
+}
+
+// page returns the last limit entries with a sequence number below before,
+// oldest first.
+func (s *spill) page(before uint64, limit int) ([]models.LogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []models.LogEntry
+	for i := len(s.segs) - 1; i >= 0 && len(out) < limit; i-- {
+		seg := s.segs[i]
+		// entries [from, to) of the segment are the ones wanted.
+		to := len(seg.offsets)
+		if before <= seg.first {
+			to = 0
+		} else if n := before - seg.first; n < uint64(to) {
+			to = int(n)
+		}
+		from := to - (limit - len(out))
+		if from < 0 {
+			from = 0
+		}
+		if from == to {
+			continue
+		}
+		path := s.path
+		if i == 0 {
+			path += ".1"
+		}
+		entries, err := seg.read(path, from, to)
+		if err != nil {
+			return nil, err
+		}
+		out = append(entries, out...)
+	}
+	return out, nil
+}
+
+// has reports whether any entry with a sequence number below before is
+// spilled.
+func (s *spill) has(before uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, seg := range s.segs {
+		if len(seg.offsets) > 0 && seg.first < before {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function reading the entries [from, to) of the segment in the file
+// at path.
+func (seg segment) read(path string, from, to int) ([]models.LogEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	end := seg.size
+	if to < len(seg.offsets) {
+		end = seg.offsets[to]
+	}
+	start := seg.offsets[from]
+	scanner := bufio.NewScanner(io.NewSectionReader(f, start, end-start))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	entries := make([]models.LogEntry, 0, to-from)
+	for scanner.Scan() {
+		var e models.LogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// ErrNotFound is returned by a store when the requested record does not exist.
+var ErrNotFound = errors.New("core: not found")
+
+// StatusStore keeps the current health status of every target. This is the
+// "current_status" collection; each target has a record named after it.
+type StatusStore interface {
+	GetStatus(ctx context.Context, name string) (models.Status, error)
+	SetStatus(ctx context.Context, name string, status models.Status) error
+	// UpdateStatus atomically replaces the status of name with fn's return
+	// value. Concurrent updates of the same name never interleave, so no
+	// counter increment or transition is lost or applied twice. fn may run
+	// more than once if the store retries on contention, so it must not have
+	// side effects; returning an error aborts the update.
+	UpdateStatus(ctx context.Context, name string, fn func(models.Status) (models.Status, error)) (models.Status, error)
+	// EnsureStatus creates a healthy status for name if it has none yet.
+	EnsureStatus(ctx context.Context, name string) error
+}
+
+// SubscriptionStore keeps the email notification subscription. This is the
+// "config" collection. A store without a subscription returns the zero value.
+type SubscriptionStore interface {
+	GetNotification(ctx context.Context) (models.Notification, error)
+	SetNotification(ctx context.Context, notify models.Notification) error
+}
+
+// LeaseStore keeps leases used for leader election.
+type LeaseStore interface {
+	// AcquireLease grants the named lease to holder until now+ttl if it is
+	// free, expired or already held by holder, and reports whether it did.
+	// The current lease is returned either way.
+	AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration, now time.Time) (models.Lease, bool, error)
+	// ReleaseLease gives up the named lease if holder holds it.
+	ReleaseLease(ctx context.Context, name string, holder string) error
+}
+
+// HistoryStore keeps the result of every probe, indexed by target and time.
+type HistoryStore interface {
+	AddResult(ctx context.Context, res models.Result) error
+	// Results returns the results of target that started in [from, to),
+	// oldest first. A positive limit caps the number of results returned,
+	// keeping the oldest ones.
+	Results(ctx context.Context, target string, from time.Time, to time.Time, limit int) ([]models.Result, error)
+	// LatestResults returns the limit most recent results of target that
+	// started before before, oldest first.
+	LatestResults(ctx context.Context, target string, before time.Time, limit int) ([]models.Result, error)
+	// Counts returns how many probes of target started in [from, to) and
+	// how many of them failed, without loading the results themselves.
+	Counts(ctx context.Context, target string, from time.Time, to time.Time) (total int, failures int, err error)
+	// PruneResults deletes the results of target that started before
+	// before and returns how many were deleted.
+	PruneResults(ctx context.Context, target string, before time.Time) (int, error)
+}
+
+// RollupStore keeps the per-minute and per-hour aggregates of the history.
+type RollupStore interface {
+	// SaveRollups creates or replaces rollups by target, resolution and start.
+	SaveRollups(ctx context.Context, rollups []models.Rollup) error
+	// Rollups returns the rollups of target at resolution that start in
+	// [from, to), oldest first.
+	Rollups(ctx context.Context, target string, resolution time.Duration, from time.Time, to time.Time) ([]models.Rollup, error)
+	// PruneRollups deletes the rollups of target at resolution that start
+	// before before and returns how many were deleted.
+	PruneRollups(ctx context.Context, target string, resolution time.Duration, before time.Time) (int, error)
+}
+
+// IncidentStore keeps the incidents of every target.
+type IncidentStore interface {
+	// SaveIncident creates or replaces the incident with the same target and
+	// opening time.
+	SaveIncident(ctx context.Context, inc models.Incident) error
+	// Incidents returns the incidents of target, or of every target when it
+	// is empty, newest first. A positive limit caps the number returned.
+	Incidents(ctx context.Context, target string, limit int) ([]models.Incident, error)
+}
+
+// Store is everything the monitor persists.
+type Store interface {
+	StatusStore
+	SubscriptionStore
+	LeaseStore
+	HistoryStore
+	RollupStore
+	IncidentStore
+	Close() error
+}
+
+// Storage backends selectable with the storage field of the configuration.
+const (
+	BackendFirestore = "firestore" // Google Cloud Firestore. The default
+	BackendMemory    = "memory"    // In memory. Nothing survives a restart
+	BackendBolt      = "bolt"      // Embedded BoltDB file on local disk
+)
+
+// OpenStore opens the storage backend selected in the configuration.
+func OpenStore(ctx context.Context, c *models.Config) (Store, error) {
+	switch c.Handlers.Storage {
+	case "", BackendFirestore:
+		return NewFirestoreStore(ctx, c.Handlers.ProjectID)
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	case BackendBolt:
+		path := c.Handlers.StoragePath
+		if path == "" {
+			path = "./monitor.db"
+		}
+		return NewBoltStore(path)
+	}
+	return nil, fmt.Errorf("core: unknown storage backend %q", c.Handlers.Storage)
+}
+
+// Helper function implementing AcquireLease on top of the current lease, if
+// found. Every backend runs it inside its own transaction.
+func grantLease(cur models.Lease, found bool, holder string, ttl time.Duration, now time.Time) (models.Lease, bool) {
+	live := found && now.Before(cur.Expires)
+	if live && cur.Holder != holder {
+		return cur, false
+	}
+	next := models.Lease{Holder: holder, Token: cur.Token, Expires: now.Add(ttl)}
+	if !live {
+		next.Token++ // a new term begins
+	}
+	return next, true
+}
+
+// Helper function returning the status a new target starts with.
+func initialStatus() models.Status {
+	return models.Status{State: models.StateHealthy}
+}
+
+// Helper function that sorts incidents newest first and keeps at most limit
+// of them when limit is positive.
+func newestIncidents(incs []models.Incident, limit int) []models.Incident {
+	sort.SliceStable(incs, func(i, j int) bool { return incs[i].Opened.After(incs[j].Opened) })
+	if limit > 0 && len(incs) > limit {
+		incs = incs[:limit]
+	}
+	return incs
+}
@@ -0,0 +1,468 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket and key names mirror the firestore collections and documents.
+var (
+	statusBucket   = []byte("current_status")
+	configBucket   = []byte("config")
+	configKey      = []byte("config")
+	leaseBucket    = []byte("leases")
+	resultBucket   = []byte("results")   // one nested bucket per target
+	incidentBucket = []byte("incidents") // one nested bucket per target
+	rollupBucket   = []byte("rollups")   // one nested bucket per target and resolution
+)
+
+// BoltStore is a Store backed by an embedded BoltDB file. Records are stored
+// as json, one bucket per firestore collection.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates the BoltDB file at path.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{statusBucket, configBucket, leaseBucket, resultBucket, incidentBucket, rollupBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Helper function to read the json record at key of bucket into v.
+func (b *BoltStore) get(bucket []byte, key []byte, v interface{}) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(bucket).Get(key)
+		if buf == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(buf, v)
+	})
+}
+
+// Helper function to write v as a json record at key of bucket.
+func (b *BoltStore) put(bucket []byte, key []byte, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put(key, buf)
+	})
+}
+
+func (b *BoltStore) GetStatus(ctx context.Context, name string) (models.Status, error) {
+	var status models.Status
+	err := b.get(statusBucket, []byte(name), &status)
+	return status, err
+}
+
+func (b *BoltStore) SetStatus(ctx context.Context, name string, status models.Status) error {
+	return b.put(statusBucket, []byte(name), status)
+}
+
+// UpdateStatus runs fn inside a single bolt read-write transaction. Bolt allows
+// one writer at a time, which serializes concurrent updates.
+func (b *BoltStore) UpdateStatus(ctx context.Context, name string, fn func(models.Status) (models.Status, error)) (models.Status, error) {
+	var next models.Status
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(statusBucket)
+		buf := bk.Get([]byte(name))
+		if buf == nil {
+			return ErrNotFound
+		}
+		var status models.Status
+		if err := json.Unmarshal(buf, &status); err != nil {
+			return err
+		}
+		if err := checkFence(ctx, leaseIn(tx)); err != nil {
+			return err
+		}
+		var err error
+		if next, err = fn(status); err != nil {
+			return err
+		}
+		if buf, err = json.Marshal(next); err != nil {
+			return err
+		}
+		return bk.Put([]byte(name), buf)
+	})
+	return next, err
+}
+
+func (b *BoltStore) EnsureStatus(ctx context.Context, name string) error {
+	buf, err := json.Marshal(initialStatus())
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(statusBucket)
+		if bk.Get([]byte(name)) != nil {
+			return nil
+		}
+		return bk.Put([]byte(name), buf)
+	})
+}
+
+func (b *BoltStore) GetNotification(ctx context.Context) (models.Notification, error) {
+	var notify models.Notification
+	err := b.get(configBucket, configKey, &notify)
+	if err == ErrNotFound {
+		return notify, nil
+	}
+	return notify, err
+}
+
+func (b *BoltStore) SetNotification(ctx context.Context, notify models.Notification) error {
+	return b.put(configBucket, configKey, notify)
+}
+
+// Helper function looking up leases within tx.
+func leaseIn(tx *bolt.Tx) func(name string) (models.Lease, bool, error) {
+	return func(name string) (models.Lease, bool, error) {
+		var cur models.Lease
+		buf := tx.Bucket(leaseBucket).Get([]byte(name))
+		if buf == nil {
+			return cur, false, nil
+		}
+		return cur, true, json.Unmarshal(buf, &cur)
+	}
+}
+
+func (b *BoltStore) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration, now time.Time) (models.Lease, bool, error) {
+	var next models.Lease
+	var ok bool
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(leaseBucket)
+		var cur models.Lease
+		buf := bk.Get([]byte(name))
+		if buf != nil {
+			if err := json.Unmarshal(buf, &cur); err != nil {
+				return err
+			}
+		}
+		next, ok = grantLease(cur, buf != nil, holder, ttl, now)
+		out, err := json.Marshal(next)
+		if err != nil {
+			return err
+		}
+		return bk.Put([]byte(name), out)
+	})
+	return next, ok, err
+}
+
+func (b *BoltStore) ReleaseLease(ctx context.Context, name string, holder string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(leaseBucket)
+		buf := bk.Get([]byte(name))
+		if buf == nil {
+			return nil
+		}
+		var cur models.Lease
+		if err := json.Unmarshal(buf, &cur); err != nil {
+			return err
+		}
+		if cur.Holder != holder {
+			return nil
+		}
+		cur.Expires = time.Time{}
+		out, err := json.Marshal(cur)
+		if err != nil {
+			return err
+		}
+		return bk.Put([]byte(name), out)
+	})
+}
+
+// Helper function encoding t so that keys sort in time order, including
+// times before 1970.
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano())^(1<<63))
+	return key
+}
+
+// AddResult stores res in the bucket of its target under its start time,
+// followed by a sequence number so results starting at the same time do not
+// overwrite each other.
+func (b *BoltStore) AddResult(ctx context.Context, res models.Result) error {
+	buf, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := checkFence(ctx, leaseIn(tx)); err != nil {
+			return err
+		}
+		bk, err := tx.Bucket(resultBucket).CreateBucketIfNotExists([]byte(res.Target))
+		if err != nil {
+			return err
+		}
+		seq, err := bk.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 16)
+		copy(key, timeKey(res.Start))
+		binary.BigEndian.PutUint64(key[8:], seq)
+		return bk.Put(key, buf)
+	})
+}
+
+func (b *BoltStore) Results(ctx context.Context, target string, from time.Time, to time.Time, limit int) ([]models.Result, error) {
+	var out []models.Result
+	end := timeKey(to)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(resultBucket).Bucket([]byte(target))
+		if bk == nil {
+			return nil
+		}
+		c := bk.Cursor()
+		for k, v := c.Seek(timeKey(from)); k != nil && bytes.Compare(k[:8], end) < 0; k, v = c.Next() {
+			var res models.Result
+			if err := json.Unmarshal(v, &res); err != nil {
+				return err
+			}
+			out = append(out, res)
+			if limit > 0 && len(out) == limit {
+				break
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+// LatestResults walks the bucket of target backwards from before.
+func (b *BoltStore) LatestResults(ctx context.Context, target string, before time.Time, limit int) ([]models.Result, error) {
+	var out []models.Result
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(resultBucket).Bucket([]byte(target))
+		if bk == nil {
+			return nil
+		}
+		c := bk.Cursor()
+		k, v := c.Seek(timeKey(before))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil && len(out) < limit; k, v = c.Prev() {
+			var res models.Result
+			if err := json.Unmarshal(v, &res); err != nil {
+				return err
+			}
+			out = append(out, res)
+		}
+		return nil
+	})
+	reverseResults(out)
+	return out, err
+}
+
+func reverseResults(results []models.Result) {
+	for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+		results[i], results[j] = results[j], results[i]
+	}
+}
+
+func (b *BoltStore) Counts(ctx context.Context, target string, from time.Time, to time.Time) (int, int, error) {
+	total, failures := 0, 0
+	end := timeKey(to)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(resultBucket).Bucket([]byte(target))
+		if bk == nil {
+			return nil
+		}
+		c := bk.Cursor()
+		for k, v := c.Seek(timeKey(from)); k != nil && bytes.Compare(k[:8], end) < 0; k, v = c.Next() {
+			var res struct {
+				Success bool `json:"success"`
+			}
+			if err := json.Unmarshal(v, &res); err != nil {
+				return err
+			}
+			total++
+			if !res.Success {
+				failures++
+			}
+		}
+		return nil
+	})
+	return total, failures, err
+}
+
+// Keys deleted per write transaction when pruning, so that the probe loops
+// are not kept waiting on one long transaction.
+const pruneBatch = 1000
+
+// Helper function that deletes the keys of a bucket that sort before end, in
+// batches of pruneBatch keys per transaction. bucket returns the bucket
+// within a transaction, or nil if it does not exist.
+func (b *BoltStore) prune(bucket func(tx *bolt.Tx) *bolt.Bucket, end []byte) (int, error) {
+	total := 0
+	for {
+		n := 0
+		err := b.db.Update(func(tx *bolt.Tx) error {
+			bk := bucket(tx)
+			if bk == nil {
+				return nil
+			}
+			var keys [][]byte
+			c := bk.Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k[:8], end) < 0 && len(keys) < pruneBatch; k, _ = c.Next() {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			for _, k := range keys {
+				if err := bk.Delete(k); err != nil {
+					return err
+				}
+			}
+			n = len(keys)
+			return nil
+		})
+		total += n
+		if err != nil || n < pruneBatch {
+			return total, err
+		}
+	}
+}
+
+func (b *BoltStore) PruneResults(ctx context.Context, target string, before time.Time) (int, error) {
+	return b.prune(func(tx *bolt.Tx) *bolt.Bucket {
+		return tx.Bucket(resultBucket).Bucket([]byte(target))
+	}, timeKey(before))
+}
+
+// Rollups are keyed by their start time within a bucket per target, which has
+// a bucket per resolution.
+func (b *BoltStore) SaveRollups(ctx context.Context, rollups []models.Rollup) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := checkFence(ctx, leaseIn(tx)); err != nil {
+			return err
+		}
+		for _, r := range rollups {
+			buf, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			tb, err := tx.Bucket(rollupBucket).CreateBucketIfNotExists([]byte(r.Target))
+			if err != nil {
+				return err
+			}
+			bk, err := tb.CreateBucketIfNotExists([]byte(r.Resolution.String()))
+			if err != nil {
+				return err
+			}
+			if err := bk.Put(timeKey(r.Start), buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Helper function returning the rollup bucket of target at resolution, or nil.
+func rollupsOf(tx *bolt.Tx, target string, resolution time.Duration) *bolt.Bucket {
+	tb := tx.Bucket(rollupBucket).Bucket([]byte(target))
+	if tb == nil {
+		return nil
+	}
+	return tb.Bucket([]byte(resolution.String()))
+}
+
+func (b *BoltStore) Rollups(ctx context.Context, target string, resolution time.Duration, from time.Time, to time.Time) ([]models.Rollup, error) {
+	var out []models.Rollup
+	end := timeKey(to)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bk := rollupsOf(tx, target, resolution)
+		if bk == nil {
+			return nil
+		}
+		c := bk.Cursor()
+		for k, v := c.Seek(timeKey(from)); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+			var r models.Rollup
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			out = append(out, r)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (b *BoltStore) PruneRollups(ctx context.Context, target string, resolution time.Duration, before time.Time) (int, error) {
+	return b.prune(func(tx *bolt.Tx) *bolt.Bucket {
+		return rollupsOf(tx, target, resolution)
+	}, timeKey(before))
+}
+
+// Incidents are keyed by their opening time within the bucket of their target.
+func (b *BoltStore) SaveIncident(ctx context.Context, inc models.Incident) error {
+	buf, err := json.Marshal(inc)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := checkFence(ctx, leaseIn(tx)); err != nil {
+			return err
+		}
+		bk, err := tx.Bucket(incidentBucket).CreateBucketIfNotExists([]byte(inc.Target))
+		if err != nil {
+			return err
+		}
+		return bk.Put(timeKey(inc.Opened), buf)
+	})
+}
+
+func (b *BoltStore) Incidents(ctx context.Context, target string, limit int) ([]models.Incident, error) {
+	var out []models.Incident
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(incidentBucket).ForEach(func(name, _ []byte) error {
+			bk := tx.Bucket(incidentBucket).Bucket(name)
+			if bk == nil || (target != "" && string(name) != target) {
+				return nil
+			}
+			// newest first, no more than limit from each target
+			c := bk.Cursor()
+			n := 0
+			for k, v := c.Last(); k != nil && (limit <= 0 || n < limit); k, v = c.Prev() {
+				var inc models.Incident
+				if err := json.Unmarshal(v, &inc); err != nil {
+					return err
+				}
+				out = append(out, inc)
+				n++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newestIncidents(out, limit), nil
+}
+
+func (b *BoltStore) Close() error { return b.db.Close() }
@@ -0,0 +1,428 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/icommit/SRETest/pkg/models"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// Project used when the configuration does not name one.
+const defaultProjectID = "cloudwalk-sre-test"
+
+// Creates a firestore client for the given project.
+func CreateClient(ctx context.Context, projectID string) (*firestore.Client, error) {
+	if projectID == "" {
+		projectID = defaultProjectID
+	}
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	return client, nil
+}
+
+// FirestoreStore is a Store backed by Cloud Firestore. Statuses live in the
+// "current_status" collection and the subscription in "config/config".
+type FirestoreStore struct {
+	client *firestore.Client
+}
+
+// NewFirestoreStore connects to Cloud Firestore in the given project.
+func NewFirestoreStore(ctx context.Context, projectID string) (*FirestoreStore, error) {
+	client, err := CreateClient(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return &FirestoreStore{client: client}, nil
+}
+
+func (f *FirestoreStore) status(name string) *firestore.DocumentRef {
+	return f.client.Collection("current_status").Doc(name)
+}
+
+func (f *FirestoreStore) config() *firestore.DocumentRef {
+	return f.client.Collection("config").Doc("config")
+}
+
+func (f *FirestoreStore) GetStatus(ctx context.Context, name string) (models.Status, error) {
+	var status models.Status
+	dc, err := f.status(name).Get(ctx)
+	if grpcstatus.Code(err) == codes.NotFound {
+		return status, ErrNotFound
+	}
+	if err != nil {
+		return status, err
+	}
+	err = dc.DataTo(&status)
+	return status, err
+}
+
+func (f *FirestoreStore) SetStatus(ctx context.Context, name string, status models.Status) error {
+	_, err := f.status(name).Set(ctx, statusFields(status))
+	return err
+}
+
+// UpdateStatus reads, evaluates and writes the status document in a firestore
+// transaction. Firestore retries the transaction when another instance
+// changed the document, or the lease of its fence, in between, calling fn
+// again with the fresh status.
+func (f *FirestoreStore) UpdateStatus(ctx context.Context, name string, fn func(models.Status) (models.Status, error)) (models.Status, error) {
+	var next models.Status
+	doc := f.status(name)
+	err := f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var status models.Status
+		dc, err := tx.Get(doc)
+		if grpcstatus.Code(err) == codes.NotFound {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := dc.DataTo(&status); err != nil {
+			return err
+		}
+		if err := checkFence(ctx, f.leaseIn(tx)); err != nil {
+			return err
+		}
+		if next, err = fn(status); err != nil {
+			return err
+		}
+		return tx.Set(doc, statusFields(next))
+	})
+	return next, err
+}
+
+// EnsureStatus creates the "current_status" document of the named target in a
+// healthy state if it does not exist yet. Existing documents are left untouched.
+func (f *FirestoreStore) EnsureStatus(ctx context.Context, name string) error {
+	fields := statusFields(initialStatus())
+	fields["timestamp"] = firestore.ServerTimestamp
+	_, err := f.status(name).Create(ctx, fields)
+	if grpcstatus.Code(err) == codes.AlreadyExists {
+		return nil
+	}
+	return err
+}
+
+func (f *FirestoreStore) GetNotification(ctx context.Context) (models.Notification, error) {
+	var notify models.Notification
+	nt, err := f.config().Get(ctx)
+	if grpcstatus.Code(err) == codes.NotFound {
+		return notify, nil
+	}
+	if err != nil {
+		return notify, err
+	}
+	err = nt.DataTo(&notify)
+	return notify, err
+}
+
+func (f *FirestoreStore) SetNotification(ctx context.Context, notify models.Notification) error {
+	_, err := f.config().Set(ctx, map[string]interface{}{
+		"email":  notify.Email,
+		"update": notify.Update,
+	}, firestore.MergeAll)
+	return err
+}
+
+func (f *FirestoreStore) lease(name string) *firestore.DocumentRef {
+	return f.client.Collection("leases").Doc(name)
+}
+
+// Helper function looking up lease documents within tx. Firestore retries tx
+// if the lease changes before it commits.
+func (f *FirestoreStore) leaseIn(tx *firestore.Transaction) func(name string) (models.Lease, bool, error) {
+	return func(name string) (models.Lease, bool, error) {
+		var cur models.Lease
+		dc, err := tx.Get(f.lease(name))
+		if grpcstatus.Code(err) == codes.NotFound {
+			return cur, false, nil
+		}
+		if err != nil {
+			return cur, false, err
+		}
+		return cur, true, dc.DataTo(&cur)
+	}
+}
+
+// AcquireLease grants the lease document in a transaction, so only one
+// instance can win a given term.
+func (f *FirestoreStore) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration, now time.Time) (models.Lease, bool, error) {
+	var next models.Lease
+	var ok bool
+	doc := f.lease(name)
+	err := f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var cur models.Lease
+		dc, err := tx.Get(doc)
+		found := err == nil
+		if err != nil && grpcstatus.Code(err) != codes.NotFound {
+			return err
+		}
+		if found {
+			if err := dc.DataTo(&cur); err != nil {
+				return err
+			}
+		}
+		next, ok = grantLease(cur, found, holder, ttl, now)
+		return tx.Set(doc, next)
+	})
+	return next, ok, err
+}
+
+func (f *FirestoreStore) ReleaseLease(ctx context.Context, name string, holder string) error {
+	doc := f.lease(name)
+	return f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var cur models.Lease
+		dc, err := tx.Get(doc)
+		if grpcstatus.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := dc.DataTo(&cur); err != nil {
+			return err
+		}
+		if cur.Holder != holder {
+			return nil
+		}
+		cur.Expires = time.Time{}
+		return tx.Set(doc, cur)
+	})
+}
+
+// AddResult stores res as a new document in the "results" collection. A
+// fenced write runs in a transaction with the lease.
+func (f *FirestoreStore) AddResult(ctx context.Context, res models.Result) error {
+	doc := f.client.Collection("results").NewDoc()
+	if _, fenced := FenceOf(ctx); !fenced {
+		_, err := doc.Set(ctx, res)
+		return err
+	}
+	return f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := checkFence(ctx, f.leaseIn(tx)); err != nil {
+			return err
+		}
+		return tx.Create(doc, res)
+	})
+}
+
+// Results queries the "results" collection. This needs a composite index on
+// target (ascending) and start (ascending), see README.
+func (f *FirestoreStore) Results(ctx context.Context, target string, from time.Time, to time.Time, limit int) ([]models.Result, error) {
+	q := f.client.Collection("results").
+		Where("target", "==", target).
+		Where("start", ">=", from).
+		Where("start", "<", to).
+		OrderBy("start", firestore.Asc)
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]models.Result, 0, len(docs))
+	for _, dc := range docs {
+		var res models.Result
+		if err := dc.DataTo(&res); err != nil {
+			return nil, err
+		}
+		out = append(out, res)
+	}
+	return out, nil
+}
+
+// LatestResults queries the "results" collection newest first. This needs a
+// second composite index on target (ascending) and start (descending), see
+// README.
+func (f *FirestoreStore) LatestResults(ctx context.Context, target string, before time.Time, limit int) ([]models.Result, error) {
+	docs, err := f.client.Collection("results").
+		Where("target", "==", target).
+		Where("start", "<", before).
+		OrderBy("start", firestore.Desc).
+		Limit(limit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]models.Result, len(docs))
+	for i, dc := range docs {
+		if err := dc.DataTo(&out[len(docs)-1-i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Counts only fetches the success field of every result in the window.
+func (f *FirestoreStore) Counts(ctx context.Context, target string, from time.Time, to time.Time) (int, int, error) {
+	iter := f.client.Collection("results").
+		Where("target", "==", target).
+		Where("start", ">=", from).
+		Where("start", "<", to).
+		Select("success").
+		Documents(ctx)
+	defer iter.Stop()
+	total, failures := 0, 0
+	for {
+		dc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		total++
+		if ok, _ := dc.Data()["success"].(bool); !ok {
+			failures++
+		}
+	}
+	return total, failures, nil
+}
+
+// Writes per batch when pruning or saving rollups. Firestore allows up to 500
+// writes in a batch.
+const firestoreBatch = 500
+
+// Helper function that deletes the documents matched by q in batches.
+func (f *FirestoreStore) prune(ctx context.Context, q firestore.Query) (int, error) {
+	total := 0
+	for {
+		docs, err := q.Limit(firestoreBatch).Documents(ctx).GetAll()
+		if err != nil || len(docs) == 0 {
+			return total, err
+		}
+		batch := f.client.Batch()
+		for _, dc := range docs {
+			batch.Delete(dc.Ref)
+		}
+		if _, err := batch.Commit(ctx); err != nil {
+			return total, err
+		}
+		total += len(docs)
+	}
+}
+
+func (f *FirestoreStore) PruneResults(ctx context.Context, target string, before time.Time) (int, error) {
+	return f.prune(ctx, f.client.Collection("results").
+		Where("target", "==", target).
+		Where("start", "<", before))
+}
+
+// Rollups are documents of the "rollups" collection named after their target,
+// resolution and start. They are written in transactions with the lease of the
+// fence, if any, of up to firestoreBatch writes each.
+func (f *FirestoreStore) SaveRollups(ctx context.Context, rollups []models.Rollup) error {
+	for len(rollups) > 0 {
+		n := len(rollups)
+		if n > firestoreBatch {
+			n = firestoreBatch
+		}
+		chunk := rollups[:n]
+		err := f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			if err := checkFence(ctx, f.leaseIn(tx)); err != nil {
+				return err
+			}
+			for _, r := range chunk {
+				id := fmt.Sprintf("%s-%s-%d", r.Target, r.Resolution, r.Start.Unix())
+				if err := tx.Set(f.client.Collection("rollups").Doc(id), r); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		rollups = rollups[n:]
+	}
+	return nil
+}
+
+// Helper function querying the rollups of target at resolution.
+func (f *FirestoreStore) rollups(target string, resolution time.Duration) firestore.Query {
+	return f.client.Collection("rollups").
+		Where("target", "==", target).
+		Where("resolution", "==", resolution)
+}
+
+func (f *FirestoreStore) Rollups(ctx context.Context, target string, resolution time.Duration, from time.Time, to time.Time) ([]models.Rollup, error) {
+	docs, err := f.rollups(target, resolution).
+		Where("start", ">=", from).
+		Where("start", "<", to).
+		OrderBy("start", firestore.Asc).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]models.Rollup, 0, len(docs))
+	for _, dc := range docs {
+		var r models.Rollup
+		if err := dc.DataTo(&r); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func (f *FirestoreStore) PruneRollups(ctx context.Context, target string, resolution time.Duration, before time.Time) (int, error) {
+	return f.prune(ctx, f.rollups(target, resolution).Where("start", "<", before))
+}
+
+// Incidents are documents of the "incidents" collection named after their id.
+func (f *FirestoreStore) SaveIncident(ctx context.Context, inc models.Incident) error {
+	doc := f.client.Collection("incidents").Doc(inc.ID)
+	return f.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if err := checkFence(ctx, f.leaseIn(tx)); err != nil {
+			return err
+		}
+		return tx.Set(doc, inc)
+	})
+}
+
+func (f *FirestoreStore) Incidents(ctx context.Context, target string, limit int) ([]models.Incident, error) {
+	q := f.client.Collection("incidents").OrderBy("opened", firestore.Desc)
+	if target != "" {
+		q = q.Where("target", "==", target)
+	}
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]models.Incident, 0, len(docs))
+	for _, dc := range docs {
+		var inc models.Incident
+		if err := dc.DataTo(&inc); err != nil {
+			return nil, err
+		}
+		out = append(out, inc)
+	}
+	return out, nil
+}
+
+func (f *FirestoreStore) Close() error { return f.client.Close() }
+
+// Helper function that spells out every field of a status document. The
+// struct tags use omitempty, which would drop counters that are back at zero.
+func statusFields(status models.Status) map[string]interface{} {
+	fields := map[string]interface{}{
+		"state":          status.State,
+		"uptime_count":   status.Uptime,
+		"downtime_count": status.Downtime,
+	}
+	if !status.Timestamp.IsZero() {
+		fields["timestamp"] = status.Timestamp
+	}
+	return fields
+}
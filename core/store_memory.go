@@ -0,0 +1,273 @@
+package core
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// MemoryStore is a Store that keeps everything in memory. It needs no setup
+// which makes it the backend of choice for tests and quick local runs.
+type MemoryStore struct {
+	mu     sync.Mutex
+	status map[string]models.Status
+	notify models.Notification
+	leases map[string]models.Lease
+	// results of each target, sorted by start time
+	history map[string][]models.Result
+	// incidents of each target, sorted by opening time
+	incidents map[string][]models.Incident
+	// rollups of each target and resolution, sorted by start time
+	rollups map[string][]models.Rollup
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		status:    make(map[string]models.Status),
+		leases:    make(map[string]models.Lease),
+		history:   make(map[string][]models.Result),
+		incidents: make(map[string][]models.Incident),
+		rollups:   make(map[string][]models.Rollup),
+	}
+}
+
+func (m *MemoryStore) GetStatus(ctx context.Context, name string) (models.Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status, ok := m.status[name]
+	if !ok {
+		return status, ErrNotFound
+	}
+	return status, nil
+}
+
+func (m *MemoryStore) SetStatus(ctx context.Context, name string, status models.Status) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status[name] = status
+	return nil
+}
+
+func (m *MemoryStore) UpdateStatus(ctx context.Context, name string, fn func(models.Status) (models.Status, error)) (models.Status, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status, ok := m.status[name]
+	if !ok {
+		return status, ErrNotFound
+	}
+	if err := checkFence(ctx, m.lease); err != nil {
+		return status, err
+	}
+	next, err := fn(status)
+	if err != nil {
+		return status, err
+	}
+	m.status[name] = next
+	return next, nil
+}
+
+func (m *MemoryStore) EnsureStatus(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.status[name]; !ok {
+		m.status[name] = initialStatus()
+	}
+	return nil
+}
+
+func (m *MemoryStore) GetNotification(ctx context.Context) (models.Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.notify, nil
+}
+
+func (m *MemoryStore) SetNotification(ctx context.Context, notify models.Notification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notify = notify
+	return nil
+}
+
+// Helper function looking up the named lease. m.mu must be held.
+func (m *MemoryStore) lease(name string) (models.Lease, bool, error) {
+	cur, found := m.leases[name]
+	return cur, found, nil
+}
+
+func (m *MemoryStore) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration, now time.Time) (models.Lease, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, found := m.leases[name]
+	next, ok := grantLease(cur, found, holder, ttl, now)
+	m.leases[name] = next
+	return next, ok, nil
+}
+
+func (m *MemoryStore) ReleaseLease(ctx context.Context, name string, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if cur, found := m.leases[name]; found && cur.Holder == holder {
+		cur.Expires = time.Time{}
+		m.leases[name] = cur
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddResult(ctx context.Context, res models.Result) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := checkFence(ctx, m.lease); err != nil {
+		return err
+	}
+	h := m.history[res.Target]
+	// results almost always arrive in order; keep the slice sorted if not.
+	i := sort.Search(len(h), func(i int) bool { return h[i].Start.After(res.Start) })
+	h = append(h, models.Result{})
+	copy(h[i+1:], h[i:])
+	h[i] = res
+	m.history[res.Target] = h
+	return nil
+}
+
+func (m *MemoryStore) Results(ctx context.Context, target string, from time.Time, to time.Time, limit int) ([]models.Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.history[target]
+	lo := sort.Search(len(h), func(i int) bool { return !h[i].Start.Before(from) })
+	hi := sort.Search(len(h), func(i int) bool { return !h[i].Start.Before(to) })
+	if lo >= hi {
+		return nil, nil
+	}
+	if limit > 0 && hi-lo > limit {
+		hi = lo + limit
+	}
+	return append([]models.Result(nil), h[lo:hi]...), nil
+}
+
+func (m *MemoryStore) LatestResults(ctx context.Context, target string, before time.Time, limit int) ([]models.Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.history[target]
+	hi := sort.Search(len(h), func(i int) bool { return !h[i].Start.Before(before) })
+	lo := hi - limit
+	if lo < 0 {
+		lo = 0
+	}
+	if lo >= hi {
+		return nil, nil
+	}
+	return append([]models.Result(nil), h[lo:hi]...), nil
+}
+
+func (m *MemoryStore) Counts(ctx context.Context, target string, from time.Time, to time.Time) (int, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.history[target]
+	lo := sort.Search(len(h), func(i int) bool { return !h[i].Start.Before(from) })
+	hi := sort.Search(len(h), func(i int) bool { return !h[i].Start.Before(to) })
+	failures := 0
+	for i := lo; i < hi; i++ {
+		if !h[i].Success {
+			failures++
+		}
+	}
+	if hi < lo {
+		hi = lo
+	}
+	return hi - lo, failures, nil
+}
+
+func (m *MemoryStore) PruneResults(ctx context.Context, target string, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.history[target]
+	n := sort.Search(len(h), func(i int) bool { return !h[i].Start.Before(before) })
+	m.history[target] = append([]models.Result(nil), h[n:]...)
+	return n, nil
+}
+
+// Helper function naming the rollups of a target at a resolution.
+func rollupKey(target string, resolution time.Duration) string {
+	return target + "/" + resolution.String()
+}
+
+func (m *MemoryStore) SaveRollups(ctx context.Context, rollups []models.Rollup) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := checkFence(ctx, m.lease); err != nil {
+		return err
+	}
+	for _, r := range rollups {
+		key := rollupKey(r.Target, r.Resolution)
+		rs := m.rollups[key]
+		i := sort.Search(len(rs), func(i int) bool { return !rs[i].Start.Before(r.Start) })
+		if i < len(rs) && rs[i].Start.Equal(r.Start) {
+			rs[i] = r
+			continue
+		}
+		rs = append(rs, models.Rollup{})
+		copy(rs[i+1:], rs[i:])
+		rs[i] = r
+		m.rollups[key] = rs
+	}
+	return nil
+}
+
+func (m *MemoryStore) Rollups(ctx context.Context, target string, resolution time.Duration, from time.Time, to time.Time) ([]models.Rollup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rs := m.rollups[rollupKey(target, resolution)]
+	lo := sort.Search(len(rs), func(i int) bool { return !rs[i].Start.Before(from) })
+	hi := sort.Search(len(rs), func(i int) bool { return !rs[i].Start.Before(to) })
+	if lo >= hi {
+		return nil, nil
+	}
+	return append([]models.Rollup(nil), rs[lo:hi]...), nil
+}
+
+func (m *MemoryStore) PruneRollups(ctx context.Context, target string, resolution time.Duration, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := rollupKey(target, resolution)
+	rs := m.rollups[key]
+	n := sort.Search(len(rs), func(i int) bool { return !rs[i].Start.Before(before) })
+	m.rollups[key] = append([]models.Rollup(nil), rs[n:]...)
+	return n, nil
+}
+
+func (m *MemoryStore) SaveIncident(ctx context.Context, inc models.Incident) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := checkFence(ctx, m.lease); err != nil {
+		return err
+	}
+	incs := m.incidents[inc.Target]
+	i := sort.Search(len(incs), func(i int) bool { return !incs[i].Opened.Before(inc.Opened) })
+	if i < len(incs) && incs[i].Opened.Equal(inc.Opened) {
+		incs[i] = inc
+		return nil
+	}
+	incs = append(incs, models.Incident{})
+	copy(incs[i+1:], incs[i:])
+	incs[i] = inc
+	m.incidents[inc.Target] = incs
+	return nil
+}
+
+func (m *MemoryStore) Incidents(ctx context.Context, target string, limit int) ([]models.Incident, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []models.Incident
+	for name, incs := range m.incidents {
+		if target == "" || name == target {
+			out = append(out, incs...)
+		}
+	}
+	return newestIncidents(out, limit), nil
+}
+
+func (m *MemoryStore) Close() error { return nil }
@@ -0,0 +1,442 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Every backend must behave the same way; firestore is left out since it
+// needs Google Cloud credentials.
+func stores(t *testing.T) map[string]Store {
+	bolt, err := NewBoltStore(filepath.Join(t.TempDir(), "monitor.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bolt.Close() })
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"bolt":   bolt,
+	}
+}
+
+func TestStoreStatus(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		if _, err := store.GetStatus(ctx, "tonto"); err != ErrNotFound {
+			t.Errorf("%s: unexpected error for a missing status: got (%v) want (%v)", name, err, ErrNotFound)
+		}
+		if err := store.EnsureStatus(ctx, "tonto"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := store.GetStatus(ctx, "tonto")
+		if err != nil || got.State != "healthy" {
+			t.Errorf("%s: unexpected initial status: got (%+v, %v)", name, got, err)
+		}
+
+		want := models.Status{State: "unhealthy", Downtime: 2, Timestamp: time.Unix(1630000000, 0).UTC()}
+		if err := store.SetStatus(ctx, "tonto", want); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// an existing status must survive EnsureStatus.
+		if err := store.EnsureStatus(ctx, "tonto"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err = store.GetStatus(ctx, "tonto")
+		if err != nil || got.State != want.State || got.Downtime != want.Downtime || !got.Timestamp.Equal(want.Timestamp) {
+			t.Errorf("%s: unexpected status: got (%+v, %v) want (%+v)", name, got, err, want)
+		}
+	}
+}
+
+func TestStoreNotification(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range stores(t) {
+		got, err := store.GetNotification(ctx)
+		if err != nil || got != (models.Notification{}) {
+			t.Errorf("%s: unexpected empty subscription: got (%+v, %v)", name, got, err)
+		}
+		want := models.Notification{Email: "oncall@example.com", Update: true}
+		if err := store.SetNotification(ctx, want); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got, err = store.GetNotification(ctx); err != nil || got != want {
+			t.Errorf("%s: unexpected subscription: got (%+v, %v) want (%+v)", name, got, err, want)
+		}
+	}
+}
+
+// Hammer one target from many goroutines at once. No failure may be lost and
+// the state must flip exactly once, however the updates interleave.
+func TestAdvanceConcurrent(t *testing.T) {
+	ctx := context.Background()
+	const workers = 50
+	for name, store := range stores(t) {
+		for _, th := range []Thresholds{{Healthy: 3, Unhealthy: 20}, {Healthy: 3, Unhealthy: 1000}} {
+			target := fmt.Sprintf("tonto-%d", th.Unhealthy)
+			if err := store.EnsureStatus(ctx, target); err != nil {
+				t.Fatal(err)
+			}
+
+			var flips int32
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					res := models.Result{Target: target, ErrorClass: models.ErrRefused}
+					_, transitions, err := Advance(ctx, store, target, res, th, time.Now())
+					if err != nil {
+						t.Error(err)
+					}
+					atomic.AddInt32(&flips, int32(len(transitions)))
+				}()
+			}
+			wg.Wait()
+
+			got, err := store.GetStatus(ctx, target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if th.Unhealthy > workers {
+				if flips != 0 || got.State != "healthy" || got.Downtime != workers {
+					t.Errorf("%s: lost updates: got (%s, downtime %d, %d flips) want (healthy, downtime %d, 0 flips)",
+						name, got.State, got.Downtime, flips, workers)
+				}
+			} else if flips != 1 || got.State != "unhealthy" {
+				t.Errorf("%s: got (%s, %d flips) want (unhealthy, exactly 1 flip)", name, got.State, flips)
+			}
+		}
+	}
+}
+
+func TestUpdateStatusMissing(t *testing.T) {
+	for name, store := range stores(t) {
+		_, err := store.UpdateStatus(context.Background(), "nobody", func(s models.Status) (models.Status, error) {
+			return s, nil
+		})
+		if err != ErrNotFound {
+			t.Errorf("%s: unexpected error: got (%v) want (%v)", name, err, ErrNotFound)
+		}
+	}
+}
+
+func TestLeases(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	leases := map[string]LeaseStore{"file": NewFileLeases(filepath.Join(t.TempDir(), "leases.json"))}
+	for name, store := range stores(t) {
+		leases[name] = store
+	}
+
+	for name, store := range leases {
+		a, ok, err := store.AcquireLease(ctx, "scheduler", "a", time.Minute, now)
+		if err != nil || !ok || a.Holder != "a" || a.Token != 1 {
+			t.Fatalf("%s: unexpected first lease: got (%+v, %v, %v)", name, a, ok, err)
+		}
+		if got, ok, _ := store.AcquireLease(ctx, "scheduler", "b", time.Minute, now.Add(30*time.Second)); ok || got.Holder != "a" {
+			t.Errorf("%s: b took a live lease: got (%+v, %v)", name, got, ok)
+		}
+		if got, ok, _ := store.AcquireLease(ctx, "scheduler", "a", time.Minute, now.Add(50*time.Second)); !ok || got.Token != 1 {
+			t.Errorf("%s: renewal changed the term: got (%+v, %v)", name, got, ok)
+		}
+		got, ok, _ := store.AcquireLease(ctx, "scheduler", "b", time.Minute, now.Add(2*time.Minute))
+		if !ok || got.Holder != "b" || got.Token != 2 {
+			t.Errorf("%s: b did not get the expired lease: got (%+v, %v)", name, got, ok)
+		}
+
+		if err := store.ReleaseLease(ctx, "scheduler", "a"); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok, _ := store.AcquireLease(ctx, "scheduler", "a", time.Minute, now.Add(2*time.Minute)); ok {
+			t.Errorf("%s: a stranger released b's lease: got (%+v)", name, got)
+		}
+		if err := store.ReleaseLease(ctx, "scheduler", "b"); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok, _ := store.AcquireLease(ctx, "scheduler", "a", time.Minute, now.Add(2*time.Minute)); !ok || got.Token != 3 {
+			t.Errorf("%s: released lease not granted: got (%+v, %v)", name, got, ok)
+		}
+	}
+}
+
+// The writes of a leader are rejected once its lease has changed hands.
+func TestFencedWrites(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	for name, store := range stores(t) {
+		if err := store.EnsureStatus(ctx, "tonto"); err != nil {
+			t.Fatal(err)
+		}
+		a, _, err := store.AcquireLease(ctx, "scheduler", "a", time.Minute, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _, err := store.AcquireLease(ctx, "scheduler", "b", time.Minute, now.Add(2*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writes := map[string]func(ctx context.Context) error{
+			"UpdateStatus": func(ctx context.Context) error {
+				_, err := store.UpdateStatus(ctx, "tonto", func(s models.Status) (models.Status, error) {
+					s.Downtime++
+					return s, nil
+				})
+				return err
+			},
+			"AddResult": func(ctx context.Context) error {
+				return store.AddResult(ctx, models.Result{Target: "tonto", Start: now})
+			},
+			"SaveIncident": func(ctx context.Context) error {
+				return store.SaveIncident(ctx, models.Incident{ID: "tonto-1", Target: "tonto", Opened: now})
+			},
+			"SaveRollups": func(ctx context.Context) error {
+				return store.SaveRollups(ctx, []models.Rollup{{Target: "tonto", Resolution: time.Minute, Start: now, Count: 1}})
+			},
+		}
+		for write, fn := range writes {
+			stale := WithFence(ctx, Fence{Lease: "scheduler", Token: a.Token})
+			if err := fn(stale); !errors.Is(err, ErrFenced) {
+				t.Errorf("%s: %s of a stale leader: got (%v) want (%v)", name, write, err, ErrFenced)
+			}
+		}
+		status, _ := store.GetStatus(ctx, "tonto")
+		results, _ := store.Results(ctx, "tonto", time.Time{}, now.Add(time.Hour), 0)
+		incs, _ := store.Incidents(ctx, "tonto", 0)
+		rollups, _ := store.Rollups(ctx, "tonto", time.Minute, time.Time{}, now.Add(time.Hour))
+		if status.Downtime != 0 || len(results) != 0 || len(incs) != 0 || len(rollups) != 0 {
+			t.Errorf("%s: stale writes were stored: %+v %v %v %v", name, status, results, incs, rollups)
+		}
+
+		// the current leader, unfenced writes and fences of other leases pass.
+		for _, ctx := range []context.Context{
+			WithFence(ctx, Fence{Lease: "scheduler", Token: b.Token}),
+			ctx,
+			WithFence(ctx, Fence{Lease: "elsewhere", Token: a.Token}),
+		} {
+			for write, fn := range writes {
+				if err := fn(ctx); err != nil {
+					t.Errorf("%s: %s: unexpected error: %v", name, write, err)
+				}
+			}
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return base.Add(time.Duration(s) * time.Second) }
+
+	for name, store := range stores(t) {
+		// out of order, with two results starting at the same time.
+		for _, s := range []int{4, 0, 2, 6, 2, 8} {
+			res := models.Result{Target: "tonto", Start: at(s), Duration: 30 * time.Millisecond, ErrorClass: models.ErrRefused}
+			if err := store.AddResult(ctx, res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := store.AddResult(ctx, models.Result{Target: "other", Start: at(3), Success: true}); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			from, to time.Time
+			limit    int
+			want     []int
+		}{
+			{at(0), at(10), 0, []int{0, 2, 2, 4, 6, 8}},
+			{at(2), at(6), 0, []int{2, 2, 4}},
+			{at(1), at(9), 2, []int{2, 2}},
+			{at(9), at(20), 0, nil},
+			{base.Add(-time.Hour), at(1), 0, []int{0}},
+		}
+		for _, tt := range tests {
+			got, err := store.Results(ctx, "tonto", tt.from, tt.to, tt.limit)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			var starts []int
+			for _, res := range got {
+				if res.Target != "tonto" || res.Duration != 30*time.Millisecond || res.ErrorClass != models.ErrRefused {
+					t.Errorf("%s: unexpected result: %+v", name, res)
+				}
+				starts = append(starts, int(res.Start.Sub(base)/time.Second))
+			}
+			if fmt.Sprint(starts) != fmt.Sprint(tt.want) {
+				t.Errorf("%s: Results(%v, %v, %d): got (%v) want (%v)", name, tt.from, tt.to, tt.limit, starts, tt.want)
+			}
+		}
+
+		latest := []struct {
+			before time.Time
+			limit  int
+			want   []int
+		}{
+			{at(10), 3, []int{4, 6, 8}},
+			{at(10), 10, []int{0, 2, 2, 4, 6, 8}},
+			{at(4), 2, []int{2, 2}},
+			{at(5), 2, []int{2, 4}},
+			{at(0), 2, nil},
+		}
+		for _, tt := range latest {
+			got, err := store.LatestResults(ctx, "tonto", tt.before, tt.limit)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			var starts []int
+			for _, res := range got {
+				if res.Target != "tonto" {
+					t.Errorf("%s: unexpected result: %+v", name, res)
+				}
+				starts = append(starts, int(res.Start.Sub(base)/time.Second))
+			}
+			if fmt.Sprint(starts) != fmt.Sprint(tt.want) {
+				t.Errorf("%s: LatestResults(%v, %d): got (%v) want (%v)", name, tt.before, tt.limit, starts, tt.want)
+			}
+		}
+	}
+}
+
+func TestHistoryCounts(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return base.Add(time.Duration(s) * time.Second) }
+
+	for name, store := range stores(t) {
+		for s := 0; s < 10; s++ {
+			if err := store.AddResult(ctx, models.Result{Target: "tonto", Start: at(s), Success: s%3 != 0}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tests := []struct {
+			from, to        time.Time
+			total, failures int
+		}{
+			{at(0), at(10), 10, 4},
+			{at(1), at(6), 5, 1},
+			{at(10), at(20), 0, 0},
+			{at(5), at(2), 0, 0},
+		}
+		for _, tt := range tests {
+			total, failures, err := store.Counts(ctx, "tonto", tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if total != tt.total || failures != tt.failures {
+				t.Errorf("%s: unexpected counts in [%v, %v): got (%d, %d) want (%d, %d)", name, tt.from, tt.to, total, failures, tt.total, tt.failures)
+			}
+		}
+	}
+}
+
+func TestIncidents(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return base.Add(time.Duration(s) * time.Second) }
+
+	for name, store := range stores(t) {
+		for _, inc := range []models.Incident{
+			{Target: "tonto", Opened: at(0), Closed: at(5), Reason: models.ErrRefused},
+			{Target: "tonto", Opened: at(20), Reason: models.ErrDNS},
+			{Target: "other", Opened: at(10), Reason: models.ErrTLS},
+		} {
+			if err := store.SaveIncident(ctx, inc); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// replaces the open incident
+		if err := store.SaveIncident(ctx, models.Incident{Target: "tonto", Opened: at(20), Closed: at(30), Reason: models.ErrDNS}); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			target string
+			limit  int
+			want   []int
+		}{
+			{"tonto", 0, []int{20, 0}},
+			{"tonto", 1, []int{20}},
+			{"", 0, []int{20, 10, 0}},
+			{"", 2, []int{20, 10}},
+			{"missing", 0, nil},
+		}
+		for _, tt := range tests {
+			incs, err := store.Incidents(ctx, tt.target, tt.limit)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			var opened []int
+			for _, inc := range incs {
+				opened = append(opened, int(inc.Opened.Sub(base)/time.Second))
+			}
+			if fmt.Sprint(opened) != fmt.Sprint(tt.want) {
+				t.Errorf("%s: unexpected incidents of %q: got (%v) want (%v)", name, tt.target, opened, tt.want)
+			}
+			if len(incs) > 0 && incs[0].Opened.Equal(at(20)) && incs[0].IsOpen() {
+				t.Errorf("%s: incident was not replaced", name)
+			}
+		}
+	}
+}
+
+func TestRollups(t *testing.T) {
+	ctx := context.Background()
+	base := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return base.Add(time.Duration(m) * time.Minute) }
+
+	for name, store := range stores(t) {
+		var rollups []models.Rollup
+		for m := 0; m < 5; m++ {
+			rollups = append(rollups, models.Rollup{Target: "tonto", Resolution: time.Minute, Start: at(m), Count: 1})
+		}
+		rollups = append(rollups, models.Rollup{Target: "tonto", Resolution: time.Hour, Start: base, Count: 60})
+		if err := store.SaveRollups(ctx, rollups); err != nil {
+			t.Fatal(err)
+		}
+		// replaces the rollup of minute 2
+		if err := store.SaveRollups(ctx, []models.Rollup{{Target: "tonto", Resolution: time.Minute, Start: at(2), Count: 2}}); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := store.Rollups(ctx, "tonto", time.Minute, at(1), at(4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 3 || got[1].Count != 2 || !got[0].Start.Equal(at(1)) {
+			t.Errorf("%s: unexpected rollups: %+v", name, got)
+		}
+
+		n, err := store.PruneRollups(ctx, "tonto", time.Minute, at(3))
+		if err != nil || n != 3 {
+			t.Errorf("%s: unexpected pruned rollups: got (%d, %v) want (%d)", name, n, err, 3)
+		}
+		if got, _ := store.Rollups(ctx, "tonto", time.Minute, base, at(10)); len(got) != 2 {
+			t.Errorf("%s: unexpected rollups after pruning: %+v", name, got)
+		}
+		if got, _ := store.Rollups(ctx, "tonto", time.Hour, base, at(60)); len(got) != 1 || got[0].Count != 60 {
+			t.Errorf("%s: unexpected hour rollups: %+v", name, got)
+		}
+
+		for s := 0; s < 10; s++ {
+			if err := store.AddResult(ctx, models.Result{Target: "tonto", Start: base.Add(time.Duration(s) * time.Second)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		n, err = store.PruneResults(ctx, "tonto", base.Add(4*time.Second))
+		if err != nil || n != 4 {
+			t.Errorf("%s: unexpected pruned results: got (%d, %v) want (%d)", name, n, err, 4)
+		}
+		if got, _ := store.Results(ctx, "tonto", base, at(1), 0); len(got) != 6 {
+			t.Errorf("%s: unexpected results after pruning: got (%d) want (%d)", name, len(got), 6)
+		}
+	}
+}
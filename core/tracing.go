@@ -0,0 +1,112 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/icommit/SRETest/pkg/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Service name reported with every span.
+const serviceName = "cloudwalk-sre-monitor"
+
+// Tracing exporters selectable with the tracing field of the configuration.
+const (
+	TracingOff    = ""       // No spans are exported. The default
+	TracingStdout = "stdout" // Spans are written to stdout as JSON
+	TracingOTLP   = "otlp"   // Spans are sent to an OTLP/HTTP collector
+)
+
+var tracer = otel.Tracer("github.com/icommit/SRETest/core")
+
+// SetupTracing installs the tracer provider selected in the configuration and
+// returns a function that flushes and stops it.
+func SetupTracing(ctx context.Context, c *models.Config) (func(context.Context) error, error) {
+	h := c.Handlers
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch h.Tracing {
+	case TracingOff:
+		return func(context.Context) error { return nil }, nil
+	case TracingStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case TracingOTLP:
+		var opts []otlptracehttp.Option
+		if h.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(h.OTLPEndpoint))
+		}
+		if h.OTLPInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("core: unknown tracing exporter %q", h.Tracing)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ratio := h.TraceRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// StartSpan starts a span of the monitor as a child of the span in ctx.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Helper function that ends span, marking it failed if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ResultContext returns ctx carrying the span of the probe that produced res,
+// so that what is done with the result joins the trace of the probe.
+func ResultContext(ctx context.Context, res models.Result) context.Context {
+	tid, err := trace.TraceIDFromHex(res.TraceID)
+	if err != nil {
+		return ctx
+	}
+	sid, err := trace.SpanIDFromHex(res.SpanID)
+	if err != nil {
+		return ctx
+	}
+	return trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    tid,
+		SpanID:     sid,
+		TraceFlags: trace.FlagsSampled,
+	}))
+}
+
+// Logf logs like log.Printf, followed by the trace id of the span in ctx if
+// it is being traced.
+func Logf(ctx context.Context, format string, args ...interface{}) {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsSampled() {
+		format += " trace_id=" + sc.TraceID().String()
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/icommit/SRETest/pkg/models"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	recorderOnce sync.Once
+	recorder     *tracetest.SpanRecorder
+)
+
+// Helper function that records the spans of the package. The global tracer
+// provider can only be installed once, so every test shares the recorder and
+// looks for the spans of its own trace.
+func spanRecorder() *tracetest.SpanRecorder {
+	recorderOnce.Do(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	})
+	return recorder
+}
+
+// Helper function that returns the names of the ended spans of a trace and
+// checks they all descend from its root.
+func traceSpans(t *testing.T, traceID string) []string {
+	t.Helper()
+	var names []string
+	parents := map[string]string{}
+	for _, s := range spanRecorder().Ended() {
+		if s.SpanContext().TraceID().String() != traceID {
+			continue
+		}
+		names = append(names, s.Name())
+		parents[s.SpanContext().SpanID().String()] = s.Parent().SpanID().String()
+	}
+	for id, parent := range parents {
+		if _, ok := parents[parent]; !ok && parent != "0000000000000000" {
+			t.Errorf("span %s has a parent outside the trace: %s", id, parent)
+		}
+	}
+	return names
+}
+
+func TestProbeSpans(t *testing.T) {
+	spanRecorder()
+	ctx := context.Background()
+	host, port := tcpServer(t, echo("secret"))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "CLOUDWALK %s\n", r.URL.Query().Get("buf"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		target models.Target
+		want   []string
+	}{
+		{models.Target{Name: "tcp", Type: "tcp", Address: net.JoinHostPort(host, port), Token: "secret", Message: "hello", Timeout: 1},
+			[]string{"dial", "auth", "echo", "probe"}},
+		{models.Target{Name: "tcp", Type: "tcp", Address: net.JoinHostPort(host, port), Token: "nope", Message: "hello", Timeout: 1},
+			[]string{"dial", "auth", "probe"}},
+		{models.Target{Name: "http", Type: "http", Address: srv.URL, Token: "secret", Message: "hello", Timeout: 1},
+			[]string{"dial", "echo", "probe"}},
+	}
+	for _, tt := range tests {
+		res, err := Probe(ctx, tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res.TraceID) != 32 || len(res.SpanID) != 16 {
+			t.Fatalf("%s: unexpected trace: got (%q, %q)", tt.target.Name, res.TraceID, res.SpanID)
+		}
+		if got := strings.Join(traceSpans(t, res.TraceID), ","); got != strings.Join(tt.want, ",") {
+			t.Errorf("%s: unexpected spans: got (%v) want (%v)", tt.target.Name, got, strings.Join(tt.want, ","))
+		}
+	}
+}
+
+func TestCheckJoinsProbeTrace(t *testing.T) {
+	spanRecorder()
+	ctx := context.Background()
+	store := NewMemoryStore()
+	if err := store.EnsureStatus(ctx, "down"); err != nil {
+		t.Fatal(err)
+	}
+	check := Checks(ctx, store, "down", 1, 1)
+	target := models.Target{Name: "down", Type: "tcp", Address: net.JoinHostPort("127.0.0.1", closedPort(t)), Timeout: 1}
+	res, err := Probe(ctx, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(res)
+
+	want := "dial,probe,persist,notify"
+	if got := strings.Join(traceSpans(t, res.TraceID), ","); got != want {
+		t.Errorf("unexpected spans: got (%v) want (%v)", got, want)
+	}
+	incs, err := store.Incidents(ctx, "down", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incs) != 1 || len(incs[0].Probes) != 1 || incs[0].Probes[0].TraceID != res.TraceID {
+		t.Errorf("unexpected incidents: %+v", incs)
+	}
+}
+
+func TestLogf(t *testing.T) {
+	var buf bytes.Buffer
+	out := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(out)
+
+	Logf(context.Background(), "%s: untraced", "tcp")
+	res := models.Result{TraceID: "0af7651916cd43dd8448eb211c80319c", SpanID: "b7ad6b7169203331"}
+	Logf(ResultContext(context.Background(), res), "%s: traced", "tcp")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected log: %q", buf.String())
+	}
+	if strings.Contains(lines[0], "trace_id") {
+		t.Errorf("unexpected trace id in %q", lines[0])
+	}
+	if !strings.HasSuffix(lines[1], "tcp: traced trace_id="+res.TraceID) {
+		t.Errorf("unexpected log line: got (%q) want trace_id=%s", lines[1], res.TraceID)
+	}
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A target is stalled once it has not been probed for this many intervals,
+// on top of the timeout a probe may take.
+const stallFactor = 2
+
+// Stall is a target whose probe loop has not completed a run in time.
+type Stall struct {
+	Target   string
+	Interval time.Duration // Interval of the target
+	Timeout  time.Duration // Timeout of the probes of the target
+	Last     time.Time     // When the target was last probed, or watched if it never was
+}
+
+// Late returns how long the target has been waiting for its probe at now.
+func (s Stall) Late(now time.Time) time.Duration {
+	return now.Sub(s.Last)
+}
+
+// Watchdog notices probe loops that stop making progress, e.g. because of a
+// hung storage call. Probe loops report every completed run with Beat. Check
+// logs, exposes in DefaultMetrics and notifies the subscriber about every
+// target that stalled or recovered since the previous check.
+type Watchdog struct {
+	Store SubscriptionStore // Subscription the alerts are sent to. No alerts when nil
+
+	mu      sync.Mutex
+	targets map[string]*watch
+}
+
+type watch struct {
+	interval time.Duration
+	timeout  time.Duration
+	last     time.Time
+	stalled  bool
+}
+
+// Watch starts watching the named target, probed every interval with probes
+// that may take up to timeout. A run of the loop waits for the probe and then
+// for the interval, so a target down behind a firewall takes interval plus
+// timeout per run: the target has that long plus another interval to complete
+// every run, the first one from now.
+func (w *Watchdog) Watch(name string, interval time.Duration, timeout time.Duration, now time.Time) {
+	if interval < time.Second {
+		interval = time.Second // a loop without a pause still takes some time per run
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.targets == nil {
+		w.targets = map[string]*watch{}
+	}
+	if t, ok := w.targets[name]; ok {
+		t.interval, t.timeout = interval, timeout
+		return
+	}
+	w.targets[name] = &watch{interval: interval, timeout: timeout, last: now}
+}
+
+// Forget stops watching the named target.
+func (w *Watchdog) Forget(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.targets, name)
+}
+
+// Beat records that the named target completed a probe run at now.
+func (w *Watchdog) Beat(name string, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t, ok := w.targets[name]; ok && now.After(t.last) {
+		t.last = now
+	}
+}
+
+// Stalled returns the watched targets that are stalled at now, by name.
+func (w *Watchdog) Stalled(now time.Time) []Stall {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var out []Stall
+	for name, t := range w.targets {
+		if t.late(now) {
+			out = append(out, Stall{Target: name, Interval: t.interval, Timeout: t.timeout, Last: t.last})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Target < out[j].Target })
+	return out
+}
+
+func (t *watch) late(now time.Time) bool {
+	return now.Sub(t.last) > StallWindow(t.interval, t.timeout)
+}
+
+// StallWindow returns how long a target probed every interval, with probes
+// that may take up to timeout, can go without a completed probe before it
+// counts as stalled.
+func StallWindow(interval time.Duration, timeout time.Duration) time.Duration {
+	return stallFactor*interval + timeout
+}
+
+// Check looks for targets that stalled or recovered since the previous check
+// and logs, exposes and notifies each change. It returns the stalled targets.
+func (w *Watchdog) Check(ctx context.Context, now time.Time) []Stall {
+	type change struct {
+		stall   Stall
+		stalled bool
+	}
+	var changes []change
+	w.mu.Lock()
+	for name, t := range w.targets {
+		if late := t.late(now); late != t.stalled {
+			t.stalled = late
+			changes = append(changes, change{Stall{Target: name, Interval: t.interval, Timeout: t.timeout, Last: t.last}, late})
+		}
+	}
+	w.mu.Unlock()
+	sort.Slice(changes, func(i, j int) bool { return changes[i].stall.Target < changes[j].stall.Target })
+
+	for _, c := range changes {
+		DefaultMetrics.SetStalled(c.stall.Target, c.stalled)
+		subject, body := stallMessage(c.stall, c.stalled, now)
+		log.Printf("%s: %s", c.stall.Target, subject)
+		if w.Store == nil {
+			continue
+		}
+		notify, err := w.Store.GetNotification(ctx)
+		if err != nil {
+			log.Printf("failed to read notification: %v", err)
+			continue
+		}
+		notifySubscriber(ctx, NotifyStall, notify, subject, body)
+	}
+	return w.Stalled(now)
+}
+
+// Run checks the watched targets every t until ctx is done.
+func (w *Watchdog) Run(ctx context.Context, t time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(t):
+		}
+		w.Check(ctx, time.Now())
+	}
+}
+
+// Helper function that writes the notification for a stalled or recovered target.
+func stallMessage(s Stall, stalled bool, now time.Time) (subject, body string) {
+	if !stalled {
+		subject = fmt.Sprintf("%s probe schedule recovered", s.Target)
+		body = fmt.Sprintf("%s was probed again at %s.", s.Target, s.Last.Format(time.RFC1123))
+		return subject, body
+	}
+	subject = fmt.Sprintf("%s probe schedule stalled", s.Target)
+	body = fmt.Sprintf("%s is probed every %s with a timeout of %s but was last probed %s ago (%s).\n"+
+		"Its status is not being updated until the probe loop recovers.",
+		s.Target, s.Interval, s.Timeout, s.Late(now).Round(time.Second), s.Last.Format(time.RFC1123))
+	return subject, body
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2021, 12, 1, 10, 0, 0, 0, time.UTC)
+	w := &Watchdog{Store: NewMemoryStore()}
+	w.Watch("tcp", 2*time.Second, 0, start)
+	w.Watch("http", 10*time.Second, 0, start)
+	defer DefaultMetrics.Forget("tcp")
+	defer DefaultMetrics.Forget("http")
+
+	tests := []struct {
+		at    time.Duration // since start
+		beat  string        // target probed at that time, if any
+		stall []string
+	}{
+		{at: 2 * time.Second, beat: "tcp"},
+		{at: 4 * time.Second, beat: "tcp"},
+		{at: 9 * time.Second, stall: []string{"tcp"}},          // missed two runs
+		{at: 21 * time.Second, stall: []string{"http", "tcp"}}, // never probed
+		{at: 22 * time.Second, beat: "tcp", stall: []string{"http"}},
+		{at: 23 * time.Second, beat: "http"},
+	}
+	for _, tt := range tests {
+		now := start.Add(tt.at)
+		if tt.beat != "" {
+			w.Beat(tt.beat, now)
+		}
+		var got []string
+		for _, s := range w.Check(ctx, now) {
+			got = append(got, s.Target)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.stall, ",") {
+			t.Errorf("%s: unexpected stalls: got (%v) want (%v)", tt.at, got, tt.stall)
+		}
+	}
+
+	var b strings.Builder
+	DefaultMetrics.WriteTo(&b)
+	if !strings.Contains(b.String(), `monitor_target_stalled{target="tcp"} 0`) {
+		t.Errorf("recovered target still exposed as stalled:\n%s", b.String())
+	}
+
+	w.Forget("tcp")
+	if stalls := w.Stalled(start.Add(time.Hour)); len(stalls) != 1 || stalls[0].Target != "http" {
+		t.Errorf("unexpected stalls after forget: %+v", stalls)
+	}
+}
+
+// A probe slower than its interval, like one timing out against a target
+// down behind a firewall, does not stall the loop.
+func TestWatchdogSlowProbe(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2021, 12, 1, 10, 0, 0, 0, time.UTC)
+	w := &Watchdog{}
+	w.Watch("tcp", 2*time.Second, 30*time.Second, start)
+	defer DefaultMetrics.Forget("tcp")
+
+	// every run takes the 30s timeout and then the 2s interval.
+	for run := 1; run <= 5; run++ {
+		now := start.Add(time.Duration(run) * 32 * time.Second)
+		if stalls := w.Check(ctx, now.Add(-time.Millisecond)); len(stalls) != 0 {
+			t.Errorf("run %d: unexpected stalls: %+v", run, stalls)
+		}
+		w.Beat("tcp", now)
+	}
+	last := start.Add(5 * 32 * time.Second)
+	if stalls := w.Check(ctx, last.Add(34*time.Second)); len(stalls) != 0 {
+		t.Errorf("unexpected stalls within the window: %+v", stalls)
+	}
+	if stalls := w.Check(ctx, last.Add(35*time.Second)); len(stalls) != 1 {
+		t.Errorf("unexpected stalls past the window: got (%+v) want (tcp)", stalls)
+	}
+}
+
+func TestStallMessage(t *testing.T) {
+	last := time.Date(2021, 12, 1, 10, 0, 0, 0, time.UTC)
+	s := Stall{Target: "tcp", Interval: 2 * time.Second, Last: last}
+	subject, body := stallMessage(s, true, last.Add(30*time.Second))
+	if subject != "tcp probe schedule stalled" || !strings.Contains(body, "last probed 30s ago") {
+		t.Errorf("unexpected message: %q %q", subject, body)
+	}
+	if subject, _ := stallMessage(s, false, last); subject != "tcp probe schedule recovered" {
+		t.Errorf("unexpected subject: %q", subject)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/icommit/SRETest/core"
+	"github.com/icommit/SRETest/pkg/models"
+	"golang.org/x/net/websocket"
+)
+
+var events = core.NewBroker(0) // Live feed of results and status changes
+
+// Comment sent to idle event streams so proxies do not time them out.
+const keepAlive = 15 * time.Second
+
+// The feed entry template of home.html, parsed on first use.
+var entryTemplate struct {
+	once sync.Once
+	t    *template.Template
+	err  error
+}
+
+// Helper function that renders the feed lines of a log entry the way the
+// home page does.
+func renderEntry(entry models.LogEntry) string {
+	entryTemplate.once.Do(func() {
+		entryTemplate.t, entryTemplate.err = template.New("home.html").Funcs(funcs).ParseFiles("./ui/html/home.html")
+	})
+	if entryTemplate.err != nil {
+		log.Printf("events: %v", entryTemplate.err)
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := entryTemplate.t.ExecuteTemplate(&buf, "entry", entry); err != nil {
+		log.Printf("events: %v", err)
+	}
+	return buf.String()
+}
+
+// Records a probe result in the frontend and pushes it to the live feed.
+func publishResult(res models.Result, threshold string) {
+	entry, ok := results.Record(res, threshold)
+	if !ok || events.Subscribers() == 0 {
+		return
+	}
+	v := res.V1()
+	events.Publish(models.Event{Type: "result", Target: res.Target, Seq: entry.Seq, Result: &v, HTML: renderEntry(entry)})
+}
+
+// Updates the status of a target in the frontend and pushes it to the live
+// feed if it changed, along with a transition event if its state did.
+func publishStatus(name string, status models.Status) {
+	prev, ok := results.Target(name)
+	if !ok {
+		return
+	}
+	results.SetStatus(name, status)
+	core.DefaultMetrics.SetStatus(name, status)
+	if prev.StatusLogs == status {
+		return
+	}
+	panel, _ := results.Target(name)
+	v := statusV1(panel)
+	if prev.StatusLogs.State != "" && prev.StatusLogs.State != status.State {
+		events.Publish(models.Event{Type: "transition", Target: name, Status: &v, From: prev.StatusLogs.State, To: status.State})
+	}
+	events.Publish(models.Event{Type: "status", Target: name, Status: &v})
+}
+
+// Server-Sent Events stream of the live feed: /events, or /events?target=<name>
+// for a single target. A client that falls behind is disconnected; browsers
+// reconnect on their own.
+func serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming Unsupported", http.StatusInternalServerError)
+		return
+	}
+	target := r.URL.Query().Get("target")
+	ch, cancel := events.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 2000\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if target != "" && ev.Target != target {
+				continue
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("events: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// The live feed over a WebSocket: /events/ws, with the same events as JSON
+// messages.
+var serveEventsWS = websocket.Handler(func(ws *websocket.Conn) {
+	defer ws.Close()
+	target := ws.Request().URL.Query().Get("target")
+	ch, cancel := events.Subscribe()
+	defer cancel()
+
+	// the client sends nothing; reading only tells us when it goes away.
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, ws)
+		close(closed)
+	}()
+	for {
+		select {
+		case <-closed:
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if target != "" && ev.Target != target {
+				continue
+			}
+			if err := websocket.JSON.Send(ws, ev); err != nil {
+				return
+			}
+		}
+	}
+})
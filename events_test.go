@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/core"
+	"github.com/icommit/SRETest/pkg/models"
+	"golang.org/x/net/websocket"
+)
+
+// Helper function that registers two targets with an empty live feed.
+func eventsFixture() {
+	results = core.NewResults(0, "")
+	events = core.NewBroker(0)
+	results.Add(models.Target{Name: "tonto", Type: "tcp"})
+	results.Add(models.Target{Name: "web", Type: "http"})
+}
+
+// Helper function that waits until n clients are subscribed to the live feed.
+func waitSubscribers(t *testing.T, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for events.Subscribers() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected subscribers: got (%v) want (%v)", events.Subscribers(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEventsSSE(t *testing.T) {
+	eventsFixture()
+	srv := httptest.NewServer(http.HandlerFunc(serveEvents))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events?target=tonto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("unexpected content type: got (%v) want (%v)", ct, "text/event-stream")
+	}
+	waitSubscribers(t, 1)
+
+	publishResult(models.Result{Target: "web", Start: time.Now(), Success: true}, "") // filtered out
+	publishResult(models.Result{Target: "tonto", Start: time.Now(), ErrorClass: models.ErrRefused}, "")
+	publishStatus("tonto", models.Status{State: models.StateHealthy})
+	publishStatus("tonto", models.Status{State: models.StateHealthy}) // unchanged
+	publishStatus("tonto", models.Status{State: models.StateUnhealthy})
+
+	var got []models.Event
+	var types []string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for len(got) < 4 && scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event: ") {
+			types = append(types, strings.TrimPrefix(line, "event: "))
+		}
+		if strings.HasPrefix(line, "data: ") {
+			var ev models.Event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, ev)
+		}
+	}
+	want := []string{"result", "status", "transition", "status"}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected events: got (%v) want (%v)", types, want)
+	}
+	if ev := got[0]; ev.Target != "tonto" || ev.Seq != 1 || ev.Result == nil || !strings.Contains(ev.HTML, "connection_refused") {
+		t.Errorf("unexpected result event: %+v", ev)
+	}
+	if ev := got[2]; ev.From != models.StateHealthy || ev.To != models.StateUnhealthy || ev.Status == nil || ev.Status.State != models.StateUnhealthy {
+		t.Errorf("unexpected transition event: %+v", ev)
+	}
+}
+
+func TestEventsWebSocket(t *testing.T) {
+	eventsFixture()
+	srv := httptest.NewServer(serveEventsWS)
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/events/ws", "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSubscribers(t, 1)
+	publishResult(models.Result{Target: "web", Start: time.Now(), Success: true}, "")
+
+	var ev models.Event
+	if err := websocket.JSON.Receive(ws, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != "result" || ev.Target != "web" || ev.Result == nil || !ev.Result.Success {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+
+	// the subscription ends with the connection.
+	ws.Close()
+	waitSubscribers(t, 0)
+}
+
+// A browser that stops reading must not hold up the probe loop.
+func TestEventsSlowClient(t *testing.T) {
+	eventsFixture()
+	events = core.NewBroker(4)
+	ch, cancel := events.Subscribe()
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			publishResult(models.Result{Target: "tonto", Start: time.Now()}, "")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing blocked on a slow client")
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != 4 || events.Subscribers() != 0 {
+		t.Errorf("unexpected events for a slow client: got (%v) want (%v)", n, 4)
+	}
+}
+
+// Only a rejected token is reported as a wrong one.
+func TestRenderEntryAuth(t *testing.T) {
+	tests := []struct {
+		res  models.Result
+		want string
+	}{
+		{models.Result{Auth: true, Success: true}, "Auth Token Accepted"},
+		{models.Result{ErrorClass: models.ErrAuth}, "Wrong Auth Token"},
+		{models.Result{ErrorClass: models.ErrRefused}, ""},
+		{models.Result{ErrorClass: models.ErrDNS}, ""},
+	}
+	for _, tt := range tests {
+		html := renderEntry(models.LogEntry{Result: tt.res})
+		for _, msg := range []string{"Auth Token Accepted", "Wrong Auth Token"} {
+			got, want := strings.Contains(html, msg), msg == tt.want
+			if got != want {
+				t.Errorf("%s: unexpected %q in entry: got (%v) want (%v)", tt.res.ErrorClass, msg, got, want)
+			}
+		}
+	}
+}
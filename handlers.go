@@ -3,14 +3,50 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
-	"cloud.google.com/go/firestore"
 	"github.com/icommit/SRETest/core"
+	"github.com/icommit/SRETest/pkg/models"
 )
 
+// Number of log entries shown when paging back through a target's log.
+const logPageSize = 50
+
+// Template helpers. Results are stored structured and only turned into
+// terminal style log lines when the page is rendered.
+var funcs = template.FuncMap{
+	// stamp formats a probe time the way our log feed displays it.
+	"stamp": func(t time.Time) string {
+		return t.Format("Mon Jan _2 15:04:05 2006")
+	},
+	// oldest returns the sequence number of the first entry of the log of the
+	// named target, or zero when there is nothing older to page back to: the
+	// entries evicted from the ring are gone without log_spill_dir.
+	"oldest": func(name string, entries []models.LogEntry) uint64 {
+		if len(entries) == 0 || !results.Older(name, entries[0].Seq) {
+			return 0
+		}
+		return entries[0].Seq
+	},
+	// days formats an SLO window, e.g. "7d".
+	"days": func(d time.Duration) string {
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	},
+	// round formats a duration to the second.
+	"round": func(d time.Duration) string {
+		return d.Round(time.Second).String()
+	},
+	// percent formats a fraction as a percentage.
+	"percent": func(f float64) string {
+		return fmt.Sprintf("%.1f%%", 100*f)
+	},
+}
+
 // Main hanlder. The frontend project has one endpoint; "/"
 // Pretty simple and straightforward; we parse our html file and pass in our
 // logwarehouse data. Logic to opt-in to email notification is defined here.
@@ -20,10 +56,8 @@ func home(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	ctx := context.Background()
-	client := core.CreateClient(ctx)
-	store := client.Collection("config").Doc("config")
 
-	ts, err := template.ParseFiles("./ui/html/home.html")
+	ts, err := template.New("home.html").Funcs(funcs).ParseFiles("./ui/html/home.html")
 	if err != nil {
 		log.Println(err.Error())
 		http.Error(w, "Internal Server Error", 500)
@@ -31,6 +65,10 @@ func home(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == "POST" {
+		if db == nil {
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
 		err := r.ParseForm()
 		if err != nil {
 			log.Println(w, http.StatusBadRequest)
@@ -40,37 +78,90 @@ func home(w http.ResponseWriter, r *http.Request) {
 		toggle := r.FormValue("toggle")
 		//toggle, _ := strconv.ParseBool(r.FormValue("toggle"))
 
+		notify, err := db.GetNotification(ctx)
+		if err != nil {
+			log.Printf("Get: An error has occurred: %s", err)
+		}
 		if email != "" {
-			_, err := store.Set(ctx, map[string]interface{}{
-				"email": email,
-			}, firestore.MergeAll)
-			if err != nil {
-				// Handle any errors in an appropriate way, such as returning them.
-				log.Printf("Set: An error has occurred: %s", err)
-			}
+			notify.Email = email
 		}
-		if toggle == "on" {
-			_, err := store.Set(ctx, map[string]interface{}{
-				"update": true,
-			}, firestore.MergeAll)
-			if err != nil {
-				// Handle any errors in an appropriate way, such as returning them.
-				log.Printf("Set: An error has occurred: %s", err)
+		notify.Update = toggle == "on"
+		if err := db.SetNotification(ctx, notify); err != nil {
+			// Handle any errors in an appropriate way, such as returning them.
+			log.Printf("Set: An error has occurred: %s", err)
+		}
+	}
+
+	data := results.Snapshot()
+	// Page back through the log of one target: /?target=<name>&before=<seq>
+	if name := r.URL.Query().Get("target"); name != "" {
+		before, _ := strconv.ParseUint(r.URL.Query().Get("before"), 10, 64)
+		for i := range data.Targets {
+			if data.Targets[i].Name != name {
+				continue
 			}
-		} else {
-			_, errs := store.Set(ctx, map[string]interface{}{
-				"update": false,
-			}, firestore.MergeAll)
-			if errs != nil {
-				// Handle any errors in an appropriate way, such as returning them.
-				log.Printf("Set: An error has occurred: %s", errs)
+			page, err := results.Page(name, before, logPageSize)
+			if err != nil {
+				log.Printf("%s: failed to page log: %v", name, err)
 			}
+			data.Targets[i].LogSlice = page
 		}
 	}
 
-	err = ts.Execute(w, warehouse)
+	err = ts.Execute(w, data)
 	if err != nil {
 		log.Println(err.Error())
 		http.Error(w, "Internal Server Error", 500)
 	}
 }
+
+// Prometheus metrics in the text exposition format.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := core.DefaultMetrics.WriteTo(w); err != nil {
+		log.Printf("metrics: %v", err)
+	}
+}
+
+// Blackbox exporter style probe: /probe?target=<address>&module=<name> runs
+// the echo check of the module against the target right away and returns the
+// metrics of that single probe. Modules are defined in the configuration.
+func serveProbe(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	address := params.Get("target")
+	if address == "" {
+		http.Error(w, "Target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	c := config()
+	if c == nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	target, err := core.ModuleTarget(c, params.Get("module"), address)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// answer before Prometheus gives up on the scrape, like the blackbox exporter.
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		scrape, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			http.Error(w, "Invalid X-Prometheus-Scrape-Timeout-Seconds header", http.StatusBadRequest)
+			return
+		}
+		if limit := int(scrape - 0.5); limit >= 1 && (target.Timeout <= 0 || limit < target.Timeout) {
+			target.Timeout = limit
+		}
+	}
+
+	res, err := core.Probe(r.Context(), target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := core.WriteProbeMetrics(w, res); err != nil {
+		log.Printf("probe: %v", err)
+	}
+}
@@ -1,9 +1,17 @@
 package main
 
 import (
+	"bufio"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/core"
+	"github.com/icommit/SRETest/pkg/models"
 )
 
 func TestHomeHandlerNotFound(t *testing.T) {
@@ -24,3 +32,153 @@ func TestHomeHandlerNotFound(t *testing.T) {
 		)
 	}
 }
+
+// Probe loops record results while the frontend renders them. Run with -race.
+func TestHomeHandlerConcurrentRender(t *testing.T) {
+	results = core.NewResults(0, "")
+	targets := []models.Target{{Name: "tcp", Type: "tcp"}, {Name: "http", Type: "http"}}
+	for _, target := range targets {
+		results.Add(target)
+	}
+
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				results.Record(models.Result{Target: name, Start: time.Now(), Success: i%2 == 0}, "")
+				results.SetStatus(name, models.Status{State: "healthy"})
+			}
+		}(target.Name)
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				rr := httptest.NewRecorder()
+				http.HandlerFunc(home).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+				if rr.Code != http.StatusOK {
+					t.Errorf("unexpected status: got (%v) want (%v)", rr.Code, http.StatusOK)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// The link to older entries shows only when there are some to page back to.
+func TestHomeHandlerOlderLink(t *testing.T) {
+	for _, spillDir := range []string{"", t.TempDir()} {
+		results = core.NewResults(2, spillDir)
+		results.Add(models.Target{Name: "tcp", Type: "tcp"})
+		for i := 0; i < 5; i++ {
+			results.Record(models.Result{Target: "tcp", Start: time.Now()}, "")
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(home).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		if got, want := strings.Contains(rr.Body.String(), "older entries"), spillDir != ""; got != want {
+			t.Errorf("unexpected older entries link with spill dir %q: got (%v) want (%v)", spillDir, got, want)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	core.DefaultMetrics.ObserveProbe(models.Result{Target: "tcp", Success: true})
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(serveMetrics).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("unexpected status: got (%v) want (%v)", rr.Code, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type: %q", ct)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, `monitor_probes_total{target="tcp",result="success",error_class="none"}`) {
+		t.Errorf("unexpected metrics:\n%s", body)
+	}
+}
+
+func TestProbeHandler(t *testing.T) {
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("auth") != "secret" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("CLOUDWALK " + r.URL.Query().Get("buf")))
+	}))
+	defer echo.Close()
+
+	conf = &models.Config{Modules: map[string]models.Module{
+		"echo":     {Prober: "http", Hosts: []string{"127.0.0.1"}, Token: "secret", Timeout: 5},
+		"bad_auth": {Prober: "http", Hosts: []string{"127.0.0.1"}, Token: "wrong", Timeout: 5},
+		"ftp":      {Prober: "ftp"},
+		"other":    {Prober: "http", Hosts: []string{"*.cloudwalk.io"}, Token: "secret"},
+	}}
+	conf.Handlers.Msg = "hello"
+	defer func() { conf = nil }()
+
+	tests := []struct {
+		query string
+		code  int
+		want  []string
+	}{
+		{"?target=" + echo.URL + "&module=echo", http.StatusOK, []string{"probe_success 1\n", "probe_echo_auth_accepted 1\n"}},
+		{"?target=" + echo.URL + "&module=bad_auth", http.StatusOK, []string{"probe_success 0\n", `probe_echo_error_class{error_class="auth_rejected"} 1`}},
+		{"?module=echo", http.StatusBadRequest, []string{"Target parameter is missing"}},
+		{"?target=" + echo.URL + "&module=missing", http.StatusBadRequest, []string{`unknown module "missing"`}},
+		{"?target=" + echo.URL + "&module=ftp", http.StatusBadRequest, []string{`unknown prober "ftp"`}},
+		{"?target=" + echo.URL + "&module=other", http.StatusBadRequest, []string{`module "other" may not probe`}},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(serveProbe).ServeHTTP(rr, httptest.NewRequest("GET", "/probe"+tt.query, nil))
+		if rr.Code != tt.code {
+			t.Errorf("%s: unexpected status: got (%v) want (%v)", tt.query, rr.Code, tt.code)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("%s: missing %q in:\n%s", tt.query, want, rr.Body)
+			}
+		}
+	}
+}
+
+// /probe is open to anyone, so a module without a token of its own must never
+// send the token of env_variables to the address it is given.
+func TestProbeHandlerNoToken(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+
+	conf = &models.Config{Modules: map[string]models.Module{
+		"tcp_echo": {Prober: "tcp", Hosts: []string{"127.0.0.1"}, Timeout: 1},
+	}}
+	conf.Handlers.Token = "prod-secret"
+	defer func() { conf = nil }()
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(serveProbe).ServeHTTP(rr, httptest.NewRequest("GET", "/probe?module=tcp_echo&target="+ln.Addr().String(), nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status: got (%v) want (%v)", rr.Code, http.StatusBadRequest)
+	}
+	select {
+	case line := <-received:
+		t.Errorf("unexpected data sent to the target: %q", line)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/icommit/SRETest/core"
+)
+
+// How long /readyz waits for the storage backend to answer.
+const readyTimeout = 2 * time.Second
+
+// readiness is the document served by /readyz.
+type readiness struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"` // "ok", or what is wrong
+}
+
+// GET /healthz. The process is alive if it can answer at all.
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// GET /readyz. The monitor is ready when its configuration is loaded, the
+// storage backend answers and every target was probed within two of its
+// intervals plus its timeout.
+func serveReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	now := time.Now()
+
+	ready := readiness{Ready: true, Checks: map[string]string{}}
+	check := func(name string, err error) {
+		if err != nil {
+			ready.Ready = false
+			ready.Checks[name] = err.Error()
+			return
+		}
+		ready.Checks[name] = "ok"
+	}
+	check("config", configReady())
+	check("storage", storageReady(ctx))
+	check("probes", probesReady(ctx, now))
+
+	code := http.StatusOK
+	if !ready.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, ready)
+}
+
+func configReady() error {
+	if config() == nil {
+		return fmt.Errorf("configuration not loaded")
+	}
+	return nil
+}
+
+func storageReady(ctx context.Context) error {
+	if db == nil {
+		return fmt.Errorf("storage not opened")
+	}
+	if _, err := db.GetNotification(ctx); err != nil {
+		return fmt.Errorf("storage unreachable: %v", err)
+	}
+	return nil
+}
+
+// Helper function that checks every target was probed recently. The leader
+// asks its watchdog. Followers do not probe, so they look for the results of
+// the leader in the probe history instead.
+func probesReady(ctx context.Context, now time.Time) error {
+	var late []string
+	if scheduler != nil && scheduler.IsLeader() {
+		for _, s := range watchdog.Stalled(now) {
+			late = append(late, fmt.Sprintf("%s last probed %s ago", s.Target, s.Late(now).Round(time.Second)))
+		}
+	} else if db != nil {
+		for _, target := range results.Targets() {
+			window := core.StallWindow(time.Duration(target.Interval)*time.Second, time.Duration(target.Timeout)*time.Second)
+			if now.Sub(started) < window {
+				continue // give the leader time to probe it
+			}
+			history, err := db.Results(ctx, target.Name, now.Add(-window), now, 1)
+			if err != nil {
+				return fmt.Errorf("failed to read probe history: %v", err)
+			}
+			if len(history) == 0 {
+				late = append(late, fmt.Sprintf("%s not probed for %s", target.Name, window))
+			}
+		}
+	}
+	if len(late) > 0 {
+		return fmt.Errorf("%s", strings.Join(late, ", "))
+	}
+	return nil
+}
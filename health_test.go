@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/core"
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+func TestHealthz(t *testing.T) {
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(serveHealthz).ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "ok" {
+		t.Errorf("unexpected response: got (%v, %q) want (%v, %q)", rr.Code, rr.Body.String(), http.StatusOK, "ok")
+	}
+}
+
+// Helper function that gets /readyz.
+func getReadyz(t *testing.T) (int, readiness) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(serveReadyz).ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	var ready readiness
+	if err := json.Unmarshal(rr.Body.Bytes(), &ready); err != nil {
+		t.Fatalf("invalid readiness %q: %v", rr.Body.String(), err)
+	}
+	return rr.Code, ready
+}
+
+func TestReadyz(t *testing.T) {
+	conf, db, scheduler, results = nil, nil, nil, core.NewResults(0, "")
+	start := started
+	t.Cleanup(func() { conf, db, scheduler, started = nil, nil, nil, start })
+
+	code, ready := getReadyz(t)
+	if code != http.StatusServiceUnavailable || ready.Ready || ready.Checks["config"] == "ok" || ready.Checks["storage"] == "ok" {
+		t.Errorf("unexpected readiness before startup: got (%v, %+v)", code, ready)
+	}
+
+	// a follower is ready once the leader probed every target.
+	ctx := context.Background()
+	store := core.NewMemoryStore()
+	conf, db = &models.Config{}, store
+	results.Add(models.Target{Name: "tcp", Type: "tcp", Interval: 2})
+	started = time.Now().Add(-time.Hour)
+	code, ready = getReadyz(t)
+	if code != http.StatusServiceUnavailable || ready.Checks["config"] != "ok" || ready.Checks["storage"] != "ok" ||
+		!strings.Contains(ready.Checks["probes"], "tcp not probed") {
+		t.Errorf("unexpected readiness without probes: got (%v, %+v)", code, ready)
+	}
+	if err := store.AddResult(ctx, models.Result{Target: "tcp", Start: time.Now().Add(-time.Second), Success: true}); err != nil {
+		t.Fatal(err)
+	}
+	if code, ready = getReadyz(t); code != http.StatusOK || !ready.Ready {
+		t.Errorf("unexpected readiness of follower: got (%v, %+v)", code, ready)
+	}
+
+	// a target down behind a firewall is probed once per interval plus timeout.
+	results.Add(models.Target{Name: "blackholed", Type: "tcp", Interval: 2, Timeout: 30})
+	defer results.Remove("blackholed")
+	if err := store.AddResult(ctx, models.Result{Target: "blackholed", Start: time.Now().Add(-32 * time.Second), ErrorClass: models.ErrConnectTimeout}); err != nil {
+		t.Fatal(err)
+	}
+	if code, ready = getReadyz(t); code != http.StatusOK || !ready.Ready {
+		t.Errorf("unexpected readiness of follower with a slow target: got (%v, %+v)", code, ready)
+	}
+
+	// the leader asks its watchdog.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	scheduler = &core.Leader{Leases: store, Name: "scheduler", ID: "test", TTL: time.Minute}
+	go scheduler.Run(ctx, func(ctx context.Context) { <-ctx.Done() })
+	for i := 0; !scheduler.IsLeader(); i++ {
+		if i == 100 {
+			t.Fatal("never elected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	watchdog.Watch("tcp", 2*time.Second, 30*time.Second, time.Now().Add(-time.Minute))
+	defer watchdog.Forget("tcp")
+	code, ready = getReadyz(t)
+	if code != http.StatusServiceUnavailable || !strings.Contains(ready.Checks["probes"], "tcp last probed 1m0s ago") {
+		t.Errorf("unexpected readiness of stalled leader: got (%v, %+v)", code, ready)
+	}
+	watchdog.Beat("tcp", time.Now())
+	if code, ready = getReadyz(t); code != http.StatusOK || !ready.Ready {
+		t.Errorf("unexpected readiness of leader: got (%v, %+v)", code, ready)
+	}
+}
@@ -2,115 +2,474 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/icommit/SRETest/core"
 	"github.com/icommit/SRETest/pkg/models"
 )
 
-var warehouse models.LogWarehouse // Combined logs for http and tcp
-var p []models.GLogs              // Logs for http endpoint
-var q []models.GLogs              // Logs for tcp endpoint
+const sloInterval = time.Minute          // How often SLOs and burn-rate alerts are evaluated
+const retentionInterval = time.Minute    // How often the history is rolled up and pruned
+const incidentCount = 20                 // Recent incidents shown, and MTTR/MTBF computed from, per target
+const watchdogInterval = 5 * time.Second // How often the watchdog looks for stalled probe loops
+const shutdownTimeout = 10 * time.Second // How long a shutdown waits for requests, probes and notifications
 
-func handleRequest() {
-	http.HandleFunc("/", home)
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-		log.Printf("Defaulting to port %s", port)
+var results = core.NewResults(0, "") // Recent results and status of every target
+var db core.Store                    // Status and subscription storage
+var conf *models.Config              // Configuration in use, see config()
+var scheduler *core.Leader           // Election of the instance running the probe loops
+var watchdog = &core.Watchdog{}      // Notices probe loops of this instance that stall
+var started = time.Now()             // When the process started
+var configPath string                // Configuration file given with -config
+var flagLayer core.Layer             // Settings given as command-line flags
+
+// configFile returns the path of the yaml configuration. It defaults to app.yaml
+// and can be pointed elsewhere with the -config flag or the CONFIG_FILE
+// environment variable, since App Engine does not accept a targets list in
+// app.yaml itself.
+func configFile() string {
+	if configPath != "" {
+		return configPath
+	}
+	if f := os.Getenv("CONFIG_FILE"); f != "" {
+		return f
 	}
+	return "./app.yaml"
+}
+
+// routes returns the handler of every endpoint.
+func routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", home)
+	handleAPI(mux)
+	mux.HandleFunc("/events", serveEvents)
+	mux.HandleFunc("/metrics", serveMetrics)
+	mux.HandleFunc("/probe", serveProbe)
+	mux.HandleFunc("/healthz", serveHealthz)
+	mux.HandleFunc("/readyz", serveReadyz)
+	mux.Handle("/events/ws", serveEventsWS)
+	return mux
+}
+
+// detached keeps the values of a context but not its cancellation, so what a
+// probe loop started can finish after the loop was told to stop.
+type detached struct{ context.Context }
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
 
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+// Run the probe registered for the target's type and pause for the target's interval.
+// Assign generated logs for the current run to the target's log warehouse entry.
+// A failing or panicking run is logged and the loop carries on with the next one
+// until ctx is done. A probe cut short by ctx is dropped, but the result of a
+// finished one is stored and checked with work, and the loop only returns once
+// its checks and their notifications are done.
+func concurrent_probe(ctx context.Context, work context.Context, target models.Target, f func(models.Result)) {
+	var pending sync.WaitGroup
+	defer pending.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(target.Interval) * time.Second):
+		}
+		recovered(target.Name, func() {
+			res, err := core.Probe(ctx, target)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			if ctx.Err() != nil {
+				return // shutting down; the failure says nothing about the target
+			}
+			core.DefaultMetrics.ObserveProbe(res)
+			pctx, span := core.StartSpan(core.ResultContext(work, res), "persist")
+			if err := db.AddResult(pctx, res); err != nil {
+				core.Logf(pctx, "%s: failed to store result: %v", target.Name, err)
+			}
+			span.End()
+			notify, err := db.GetNotification(work)
+			if err != nil {
+				log.Printf("failed to read notification: %v", err)
+			}
+			publishResult(res, core.ThresholdMessage(res.Target))
+			results.SetNotification(notify)
+			// run function in its own goroutine, then show its outcome.
+			pending.Add(1)
+			go recovered(target.Name, func() {
+				defer pending.Done()
+				f(res)
+				refreshStatus(work, target.Name)
+			})
+		})
+		watchdog.Beat(target.Name, time.Now())
+	}
 }
 
-// Concurrently run HttpState function from core and pause for interval "t"
-// Assign generated logs for current run to the appropriate logwarehouse entry for http.
-func concurrent_http(f func(bool, models.GLogs, models.Status), t time.Duration) {
+// Report the SLO of target every t until ctx is done. The monitor sends the
+// burn-rate alerts.
+func report_slo(ctx context.Context, monitor *core.SLOMonitor, target models.Target, t time.Duration) {
 	for {
-		time.Sleep(t * time.Second)
-		C, err := core.ReadConf("./app.yaml")
-		if err != nil {
-			log.Fatal(err)
+		recovered(target.Name, func() {
+			report, err := monitor.Check(ctx, target, time.Now())
+			if err != nil {
+				log.Printf("%s: failed to report SLO: %v", target.Name, err)
+				return
+			}
+			results.SetSLO(target.Name, report)
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(t):
 		}
+	}
+}
+
+// Run one probe loop per target until ctx is done. Only the elected leader does this.
+// Checks of the last probes run with work, see concurrent_probe. Targets added,
+// removed or changed by a reload start, stop or restart their loops.
+func schedule(ctx context.Context, work context.Context, retention *core.Retention) {
+	// the checks outlive the term, but must not write once it is over.
+	if fence, ok := core.FenceOf(ctx); ok {
+		work = core.WithFence(work, fence)
+	}
+	l := &loops{ctx: ctx, work: work, monitor: &core.SLOMonitor{Store: db}, running: map[string]*loop{}}
+	l.wg.Add(2)
+	go func() {
+		defer l.wg.Done()
+		retention.Run(ctx, targetNames, retentionInterval)
+	}()
+	go func() {
+		defer l.wg.Done()
+		watchdog.Run(ctx, watchdogInterval)
+	}()
+	setLoops(l)
+	l.apply(results.Targets())
+	<-ctx.Done()
+	setLoops(nil)
+	l.wait()
+}
+
+// loops are the probe and SLO loops of the targets for a term as leader.
+type loops struct {
+	ctx, work context.Context
+	monitor   *core.SLOMonitor
+	wg        sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[string]*loop // by target name
+}
 
-		// We want the first 500 log items before reseting our collection.
-		if len(p) == 500 {
-			p = nil
+type loop struct {
+	target models.Target
+	cancel context.CancelFunc
+}
+
+var current struct {
+	sync.Mutex
+	loops *loops // nil unless leading
+}
+
+func setLoops(l *loops) {
+	current.Lock()
+	defer current.Unlock()
+	current.loops = l
+}
+
+func currentLoops() *loops {
+	current.Lock()
+	defer current.Unlock()
+	return current.loops
+}
+
+// apply starts the loops of new targets, stops those of targets that are gone
+// and restarts those of targets whose configuration changed.
+func (l *loops) apply(targets []models.Target) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ctx.Err() != nil {
+		return
+	}
+	want := map[string]bool{}
+	for _, target := range targets {
+		want[target.Name] = true
+		if running, ok := l.running[target.Name]; ok {
+			if reflect.DeepEqual(running.target, target) {
+				continue
+			}
+			l.stop(target.Name)
+		}
+		l.start(target)
+	}
+	for name := range l.running {
+		if !want[name] {
+			l.stop(name)
 		}
+	}
+}
 
-		token := C.Handlers.Token
-		timeout := C.Handlers.Timeout
-		msg := C.Handlers.Msg
-		http, i, h := core.HttpState(C.Handlers.HttpUrl, token, msg, timeout)
-		warehouse.ClientLogs = i
-		warehouse.StatusLogs = h
-		warehouse.Notification.Email = i.Email
-		warehouse.Notification.Update = i.Update
+func (l *loops) start(target models.Target) {
+	ctx, cancel := context.WithCancel(l.ctx)
+	l.running[target.Name] = &loop{target: target, cancel: cancel}
+	// the watchdog only follows the loops of this term as leader.
+	watchdog.Watch(target.Name, time.Duration(target.Interval)*time.Second, time.Duration(target.Timeout)*time.Second, time.Now())
+	if target.SLO.Enabled() {
+		l.wg.Add(1)
+		go func() {
+			defer l.wg.Done()
+			report_slo(ctx, l.monitor, target, sloInterval)
+		}()
+	}
+	f := core.Checks(l.work, db, target.Name, target.HThreshold, target.UhThreshold)
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		concurrent_probe(ctx, l.work, target, f)
+	}()
+}
 
-		p = append(p, i)
-		warehouse.LogSlice = p
+func (l *loops) stop(name string) {
+	l.running[name].cancel()
+	delete(l.running, name)
+	watchdog.Forget(name)
+}
 
-		go f(http, i, h) // run function in its own goroutine
+// wait waits for every loop to return once ctx is done.
+func (l *loops) wait() {
+	l.wg.Wait()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for name := range l.running {
+		l.stop(name)
 	}
 }
 
-// Concurrently run TcpState from core and pause for interval amount "t".
-// Assign generated logs for the current run to the appropriate log warehouse for tcp.
-func concurrent_tcp(f func(bool, models.GLogs, models.Status), t time.Duration) {
-	for {
-		time.Sleep(t * time.Second)
+// Helper function that returns the names of the monitored targets.
+func targetNames() []string {
+	targets := results.Targets()
+	names := make([]string, 0, len(targets))
+	for _, target := range targets {
+		names = append(names, target.Name)
+	}
+	return names
+}
 
-		C, err := core.ReadConf("./app.yaml")
-		if err != nil {
-			log.Fatal(err)
+// Followers do not probe, so they keep their frontend current by reading the
+// status the leader stores for each target.
+func follow(ctx context.Context, leader *core.Leader, t time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(t):
 		}
-		// we want the first 500 log items to display in our frontend before freeing memory.
-		if len(q) == 500 {
-			q = nil
+		if leader.IsLeader() {
+			continue
 		}
+		for _, name := range targetNames() {
+			refreshStatus(ctx, name)
+		}
+	}
+}
 
-		host := C.Handlers.TcpUrl
-		port := C.Handlers.Port
-		token := C.Handlers.Token
-		timeout := C.Handlers.Timeout
-		msg := C.Handlers.Msg
-		tcp, i, w := core.TcpState(host, port, token, msg, timeout)
-		warehouse.TcpLogWarehouse.ClientLogs = i
-		warehouse.TcpLogWarehouse.StatusLogs = w
+// Helper function that reads the status and recent incidents of a target into
+// the frontend.
+func refreshStatus(ctx context.Context, name string) {
+	status, err := db.GetStatus(ctx, name)
+	if err != nil {
+		log.Printf("%s: failed to read status: %v", name, err)
+		return
+	}
+	refreshIncidents(ctx, name)
+	publishStatus(name, status)
+}
 
-		q = append(q, i)
-		warehouse.TcpLogWarehouse.LogSlice = q
-		go f(tcp, i, w)
+// Helper function that reads the recent incidents of a target into the frontend.
+func refreshIncidents(ctx context.Context, name string) {
+	incs, err := db.Incidents(ctx, name, incidentCount)
+	if err != nil {
+		log.Printf("%s: failed to read incidents: %v", name, err)
+		return
 	}
+	results.SetIncidents(name, incs)
+}
+
+// recovered runs fn and logs instead of crashing if it panics. A single
+// misbehaving target must never take the whole monitor down.
+func recovered(name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%s: recovered from panic: %v\n%s", name, r, debug.Stack())
+		}
+	}()
+	fn()
 }
 
 func main() {
-	ctx := context.Background()
-	C, err := core.ReadConf("./app.yaml")
+	command, args := "", os.Args[1:]
+	switch {
+	case len(args) > 0 && args[0] == "check-config":
+		command, args = args[0], args[1:]
+	case len(args) > 1 && args[0] == "config" && args[1] == "dump":
+		command, args = "config dump", args[2:]
+	}
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.StringVar(&configPath, "config", "", "configuration file, instead of CONFIG_FILE or ./app.yaml")
+	flags := core.Flags(fs)
+	fs.Parse(args)
+	flagLayer = flags()
+
+	switch command {
+	case "check-config":
+		os.Exit(checkConfig(fs.Args(), os.Stdout))
+	case "config dump":
+		os.Exit(dumpConfig(os.Stdout))
+	}
+	if fs.NArg() > 0 {
+		log.Fatalf("unknown command %q, want check-config or config dump", fs.Arg(0))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+		log.Printf("Defaulting to port %s", port)
+	}
+	ln, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := run(ctx, ln); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	i := C.Handlers.Interval
-	hThreshold := C.Handlers.HThreshold
-	uhThreshold := C.Handlers.UhThreshold
+// run serves the monitor on ln until ctx is done, then shuts down gracefully:
+// the server stops taking requests, the probe loops stop, and the checks and
+// notifications of their last probes are flushed, all within shutdownTimeout.
+func run(ctx context.Context, ln net.Listener) error {
+	defer ln.Close()
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	C, err := loadConfig(configFile())
+	if err != nil {
+		return err
+	}
+	setConfig(C)
+	started = time.Now()
 
-	client := core.CreateClient(ctx)
-	defer client.Close()
+	shutdownTracing, err := core.SetupTracing(ctx, C)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(tctx); err != nil {
+			log.Printf("failed to flush traces: %v", err)
+		}
+	}()
 
-	// core Check function for tcp
-	a, t := core.Checks(ctx, client, "tcp", i, hThreshold, uhThreshold)
+	db, err = core.OpenStore(ctx, C)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
 
-	// core check function for http
-	b, h := core.Checks(ctx, client, "http", i, hThreshold, uhThreshold)
-	warehouse = h
-	warehouse.TcpLogWarehouse = t.TcpLogWarehouse
+	// one results entry per target
+	if dir := C.Handlers.LogSpillDir; dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	results = core.NewResults(C.Handlers.LogCapacity, C.Handlers.LogSpillDir)
+	events = core.NewBroker(C.Handlers.EventBuffer)
+	for _, target := range core.Targets(C) {
+		if err := db.EnsureStatus(ctx, target.Name); err != nil {
+			return fmt.Errorf("%s: failed to create status: %v", target.Name, err)
+		}
+		results.Add(target)
+	}
+	watchConfig(ctx)
 
-	go concurrent_tcp(a, time.Duration(i))  // pass in tcp client and run in a separate thread
-	go concurrent_http(b, time.Duration(i)) // pass in http and run in a separate thread
-	handleRequest()                         // the fun begins
-	time.Sleep(1 * time.Second)
+	// only the instance holding the scheduler lease runs the probe loops.
+	var leases core.LeaseStore = db
+	if C.Handlers.LeaseFile != "" {
+		leases = core.NewFileLeases(C.Handlers.LeaseFile)
+	}
+	ttl := C.Handlers.LeaseTTL
+	if ttl <= 0 {
+		ttl = 15
+	}
+	scheduler = &core.Leader{
+		Leases: leases,
+		Name:   "scheduler",
+		ID:     core.InstanceID(),
+		TTL:    time.Duration(ttl) * time.Second,
+	}
+	retention := core.NewRetention(db, C)
+	watchdog.Store = db
+	// the checks of the last probes outlive ctx until the flush gives up.
+	work, cancelWork := context.WithCancel(detached{ctx})
+	defer cancelWork()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		scheduler.Run(ctx, func(ctx context.Context) { schedule(ctx, work, retention) })
+	}()
+	go func() {
+		defer wg.Done()
+		follow(ctx, scheduler, time.Duration(C.Handlers.Interval)*time.Second)
+	}()
+
+	server := &http.Server{Handler: routes()}
+	server.RegisterOnShutdown(events.Close) // live feeds never go idle on their own
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ln) }() // the fun begins
+
+	select {
+	case err = <-served:
+		log.Printf("server stopped: %v", err)
+	case <-ctx.Done():
+		log.Printf("shutting down")
+	}
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(sctx); err != nil {
+		log.Printf("failed to stop server: %v", err)
+	}
+
+	stop() // in case the server failed on its own
+	flushed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		log.Printf("shut down cleanly")
+	case <-sctx.Done():
+		log.Printf("gave up waiting for probe loops to stop")
+		cancelWork()
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
 }
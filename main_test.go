@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+// Helper function that starts a tcp echo server accepting token.
+func echoServer(t *testing.T, token string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					line = strings.TrimSuffix(line, "\n")
+					switch {
+					case line == "auth "+token:
+						fmt.Fprint(conn, "auth ok\n")
+					case strings.HasPrefix(line, "auth "):
+						fmt.Fprint(conn, "auth failed\n")
+					default:
+						fmt.Fprintf(conn, "CLOUDWALK %s\n", line)
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// Helper function that writes the monitor configuration with targets, given
+// as yaml, to path.
+func writeConfig(t *testing.T, path string, targets string) {
+	t.Helper()
+	config := `env_variables:
+  auth_token: "secret"
+  message: "hello"
+  timeout: 1
+  interval: 1
+  healthy_threshold: 1
+  unhealthy_threshold: 1
+  storage: "memory"
+  lease_ttl: 3
+targets:
+` + targets
+	if err := ioutil.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Helper function that runs the monitor in-process on a local port with the
+// configuration at path. It returns the base url of the server and a function
+// stopping the monitor, like SIGTERM would, that returns the error of run.
+func startRun(t *testing.T, path string) (string, func() error) {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", path)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- run(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		db, conf, scheduler = nil, nil, nil
+	})
+	return "http://" + ln.Addr().String(), func() error {
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(shutdownTimeout):
+			t.Fatal("monitor did not stop")
+			return nil
+		}
+	}
+}
+
+// Helper function that waits up to 10 seconds for ok to hold.
+func eventually(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	for i := 0; !ok(); i++ {
+		if i == 100 {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Helper function that gets a document of the JSON API into v.
+func getJSON(url string, v interface{}) bool {
+	resp, err := http.Get(url)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(v) == nil
+}
+
+// Starts the whole monitor in-process against a local echo server, waits for
+// it to probe, then stops it like SIGTERM would.
+func TestRunShutdown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	writeConfig(t, path, fmt.Sprintf("- name: echo\n  type: tcp\n  address: %s\n", echoServer(t, "secret")))
+	base, stop := startRun(t, path)
+
+	var status models.StatusV1
+	eventually(t, "first probe", func() bool {
+		return getJSON(base+"/api/v1/targets/echo/status", &status) && status.LastResult != nil
+	})
+	if !status.LastResult.Success {
+		t.Errorf("unexpected result: %+v", status.LastResult)
+	}
+
+	// a live feed is open when the signal arrives.
+	feed, err := http.Get(base + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer feed.Body.Close()
+
+	if err := stop(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := ioutil.ReadAll(feed.Body); err != nil {
+		t.Errorf("live feed not ended cleanly: %v", err)
+	}
+	if _, err := net.Dial("tcp", strings.TrimPrefix(base, "http://")); err == nil {
+		t.Errorf("still listening after shutdown")
+	}
+	if scheduler.IsLeader() {
+		t.Errorf("still leading after shutdown")
+	}
+}
+
+// Targets are added, changed and removed by editing the configuration file
+// while the monitor runs, and an invalid edit is ignored.
+func TestRunReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	address := echoServer(t, "secret")
+	writeConfig(t, path, fmt.Sprintf("- name: a\n  type: tcp\n  address: %s\n", address))
+	base, stop := startRun(t, path)
+	defer stop()
+
+	var status models.StatusV1
+	eventually(t, "first probe of a", func() bool {
+		return getJSON(base+"/api/v1/targets/a/status", &status) && status.LastResult != nil
+	})
+
+	writeConfig(t, path, fmt.Sprintf("- name: a\n  type: tcp\n  address: %s\n  message: changed\n"+
+		"- name: b\n  type: tcp\n  address: %s\n", address, address))
+	eventually(t, "first probe of b", func() bool {
+		return getJSON(base+"/api/v1/targets/b/status", &status) && status.LastResult != nil
+	})
+	eventually(t, "changed message of a", func() bool {
+		return getJSON(base+"/api/v1/targets/a/status", &status) && status.LastResult != nil && status.LastResult.Sent == "changed"
+	})
+
+	names := func() string {
+		var targets models.TargetsV1
+		if !getJSON(base+"/api/v1/targets", &targets) {
+			return ""
+		}
+		var names []string
+		for _, target := range targets.Targets {
+			names = append(names, target.Name)
+		}
+		return strings.Join(names, ",")
+	}
+	ioutil.WriteFile(path, []byte("targets: [\n"), 0600)
+	time.Sleep(time.Second)
+	if got := names(); got != "a,b" {
+		t.Errorf("unexpected targets after an invalid edit: got (%v) want (%v)", got, "a,b")
+	}
+
+	writeConfig(t, path, fmt.Sprintf("- name: b\n  type: tcp\n  address: %s\n", address))
+	eventually(t, "removal of a", func() bool { return names() == "b" })
+	l := currentLoops()
+	if l == nil {
+		t.Fatal("not leading")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.running["b"]; !ok || len(l.running) != 1 {
+		t.Errorf("unexpected probe loops after removal: %v", l.running)
+	}
+}
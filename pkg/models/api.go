@@ -0,0 +1,134 @@
+package models
+
+import "time"
+
+// JSON documents served by the /api/v1 endpoints. Their fields are part of the
+// API: fields may be added, but never renamed or removed within v1. Times are
+// RFC 3339 and durations are in milliseconds.
+
+// TargetV1 describes a monitored target. GET /api/v1/targets
+type TargetV1 struct {
+	Name               string `json:"name"`
+	Type               string `json:"type"`
+	Address            string `json:"address"`
+	IntervalSeconds    int    `json:"interval_seconds"`
+	TimeoutSeconds     int    `json:"timeout_seconds"`
+	HealthyThreshold   int    `json:"healthy_threshold"`
+	UnhealthyThreshold int    `json:"unhealthy_threshold"`
+	SLO                *SLO   `json:"slo"`   // Null without an objective
+	State              string `json:"state"` // healthy or unhealthy
+}
+
+// TargetsV1 is the list of targets in configuration order.
+type TargetsV1 struct {
+	Targets []TargetV1 `json:"targets"`
+}
+
+// StatusV1 is the current health of a target. GET /api/v1/targets/{name}/status
+type StatusV1 struct {
+	Target        string      `json:"target"`
+	State         string      `json:"state"`          // healthy or unhealthy
+	UptimeCount   int         `json:"uptime_count"`   // Successes towards the healthy threshold
+	DowntimeCount int         `json:"downtime_count"` // Failures towards the unhealthy threshold
+	ChangedAt     time.Time   `json:"changed_at"`     // Time of the last update of the status
+	LastResult    *ResultV1   `json:"last_result"`    // Null before the first probe
+	OpenIncident  *IncidentV1 `json:"open_incident"`  // Null while the target is up
+	SLO           *SLOV1      `json:"slo"`            // Null without an objective or before the first report
+}
+
+// SLOV1 is the availability of a target against its objective.
+type SLOV1 struct {
+	Objective       float64            `json:"objective"`        // Percentage of probes that must succeed
+	WindowDays      int                `json:"window_days"`      // Window of the objective
+	Availability    map[string]float64 `json:"availability"`     // Percentage by window: "1d", "7d" and "30d"
+	BudgetRemaining float64            `json:"budget_remaining"` // Fraction of the error budget left. Negative once overspent
+	Alerts          []string           `json:"alerts"`           // Burn-rate alerts currently firing
+	ComputedAt      time.Time          `json:"computed_at"`
+}
+
+// ResultV1 is the result of a single probe.
+type ResultV1 struct {
+	Target     string    `json:"target"`
+	Type       string    `json:"type"`
+	Start      time.Time `json:"start"`
+	DurationMs float64   `json:"duration_ms"`
+	Auth       bool      `json:"auth"`
+	Sent       string    `json:"sent"`
+	Received   string    `json:"received"`
+	Success    bool      `json:"success"`
+	ErrorClass string    `json:"error_class"` // Empty on success
+	Error      string    `json:"error"`
+	TraceID    string    `json:"trace_id,omitempty"` // Trace of the probe, if it was traced
+}
+
+// ResultsV1 is a page of probe history, oldest first.
+// GET /api/v1/targets/{name}/results?since=&limit=
+type ResultsV1 struct {
+	Results []ResultV1 `json:"results"`
+}
+
+// IncidentV1 is an outage of a target.
+type IncidentV1 struct {
+	ID           string     `json:"id"`
+	Target       string     `json:"target"`
+	Open         bool       `json:"open"`
+	Opened       time.Time  `json:"opened"`
+	Closed       *time.Time `json:"closed"` // Null while open
+	DurationMs   float64    `json:"duration_ms"`
+	Reason       string     `json:"reason"` // Error class of the first failure
+	Error        string     `json:"error"`
+	TotalProbes  int        `json:"total_probes"`  // Probes run while open. Counted on close
+	FailedProbes int        `json:"failed_probes"` // Probes that failed while open
+	Probes       []ResultV1 `json:"probes"`        // The failed probes that opened the incident
+}
+
+// IncidentsV1 is a list of incidents, newest first. GET /api/v1/incidents
+type IncidentsV1 struct {
+	Incidents []IncidentV1 `json:"incidents"`
+}
+
+// Milliseconds converts a duration for the API.
+func Milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// V1 returns the API document of a result.
+func (r Result) V1() ResultV1 {
+	return ResultV1{
+		Target:     r.Target,
+		Type:       r.Type,
+		Start:      r.Start,
+		DurationMs: Milliseconds(r.Duration),
+		Auth:       r.Auth,
+		Sent:       r.Sent,
+		Received:   r.Received,
+		Success:    r.Success,
+		ErrorClass: string(r.ErrorClass),
+		Error:      r.Error,
+		TraceID:    r.TraceID,
+	}
+}
+
+// V1 returns the API document of an incident.
+func (i Incident) V1() IncidentV1 {
+	v := IncidentV1{
+		ID:           i.ID,
+		Target:       i.Target,
+		Open:         i.IsOpen(),
+		Opened:       i.Opened,
+		DurationMs:   Milliseconds(i.Duration),
+		Reason:       string(i.Reason),
+		Error:        i.Error,
+		TotalProbes:  i.Total,
+		FailedProbes: i.Failures,
+		Probes:       make([]ResultV1, 0, len(i.Probes)),
+	}
+	if !i.IsOpen() {
+		closed := i.Closed
+		v.Closed = &closed
+	}
+	for _, p := range i.Probes {
+		v.Probes = append(v.Probes, p.V1())
+	}
+	return v
+}
@@ -7,6 +7,7 @@ import "time"
 type Config struct {
 	Handlers struct {
 		Token       string `yaml:"auth_token"`          // authentication token
+		TokenFile   string `yaml:"auth_token_file"`     // file holding the authentication token, instead of auth_token
 		TcpUrl      string `yaml:"tcp_url"`             // tcp url
 		Port        string `yaml:"port"`                // tcp port
 		HttpUrl     string `yaml:"http_url"`            // http url
@@ -16,20 +17,114 @@ type Config struct {
 		HThreshold  int    `yaml:"healthy_threshold"`   // healthy threshold
 		UhThreshold int    `yaml:"unhealthy_threshold"` // unhealthy threshold
 
-		Sender    string `yaml:"sender"`    // Email Notification: Sender email
-		Recipient string `yaml:"recipient"` // Recipient. This field is no longer used. Notification collection field is used.
-		Domain    string `yaml:"domain"`    // mailgun specific configuration.
-		APIKey    string `yaml:"api_key"`   // mailgun api key
+		Sender     string `yaml:"sender"`       // Email Notification: Sender email
+		Recipient  string `yaml:"recipient"`    // Recipient. This field is no longer used. Notification collection field is used.
+		Domain     string `yaml:"domain"`       // mailgun specific configuration.
+		APIKey     string `yaml:"api_key"`      // mailgun api key
+		APIKeyFile string `yaml:"api_key_file"` // file holding the mailgun api key, instead of api_key
+
+		Storage     string `yaml:"storage"`       // Storage backend: firestore (default), memory or bolt
+		ProjectID   string `yaml:"project_id"`    // Google Cloud project of the firestore backend
+		StoragePath string `yaml:"storage_path"`  // Database file of the bolt backend
+		LeaseTTL    int    `yaml:"lease_ttl"`     // Seconds a scheduler lease lasts without renewal
+		LeaseFile   string `yaml:"lease_file"`    // Optional lock file to elect a leader among local processes
+		LogCapacity int    `yaml:"log_capacity"`  // Log entries kept in memory per target
+		LogSpillDir string `yaml:"log_spill_dir"` // Optional directory keeping older log entries on disk
+		EventBuffer int    `yaml:"event_buffer"`  // Live feed events buffered per browser before it is dropped
+
+		Tracing      string  `yaml:"tracing"`       // Span exporter: stdout or otlp. Tracing is off when empty
+		OTLPEndpoint string  `yaml:"otlp_endpoint"` // host:port of the OTLP/HTTP collector. Defaults to localhost:4318
+		OTLPInsecure bool    `yaml:"otlp_insecure"` // Send spans to the collector over plain http
+		TraceRatio   float64 `yaml:"trace_ratio"`   // Fraction of probes traced. Defaults to all of them
+
+		SLOObjective  float64 `yaml:"slo_objective"`   // Default availability objective of every target, in percent
+		SLOWindowDays int     `yaml:"slo_window_days"` // Default rolling window of the objective, in days
+
+		RawRetention    int `yaml:"retention_raw_hours"`   // Hours every probe result is kept
+		MinuteRetention int `yaml:"retention_minute_days"` // Days per-minute rollups are kept
+		HourRetention   int `yaml:"retention_hour_days"`   // Days per-hour rollups are kept
 	} `yaml:"env_variables"`
+
+	// Endpoints to monitor. When empty, the tcp and http echo servers from
+	// env_variables are monitored under the names "tcp" and "http".
+	Targets []Target `yaml:"targets"`
+
+	// Modules of the blackbox exporter style /probe endpoint, by name.
+	Modules map[string]Module `yaml:"modules"`
 }
 
-// Status typed collection holds the data from Firebase Cloud Firestore.
-// The struct reads and write data to the "current_status" collection.
+// Module describes how the /probe endpoint checks the target given in the
+// query string. The message and timeout fall back to the matching field in
+// env_variables. The token never does: /probe is open to anyone who can reach
+// the monitor, so a module only ever sends its own token, and only to hosts it
+// allows.
+type Module struct {
+	Prober    string   `yaml:"prober"`     // Registered probe type, e.g. "http" or "tcp"
+	Hosts     []string `yaml:"hosts"`      // Hosts the module may probe, as patterns like "*.cloudwalk.io"
+	Token     string   `yaml:"token"`      // authentication token
+	TokenFile string   `yaml:"token_file"` // file holding the authentication token, instead of token
+	Message   string   `yaml:"message"`    // the message to send to the echo server
+	Timeout   int      `yaml:"timeout"`    // timeout in seconds
+}
+
+// Status typed collection holds the current health of a target.
+// The struct reads and write data to the "current_status" collection of the store.
 type Status struct {
-	State     string    `firestore:"state,omitempty"`          // Field is either healthy or unhealthy
-	Uptime    int       `firestore:"uptime_count,omitempty"`   // Healthy Threshold field
-	Downtime  int       `firestore:"downtime_count,omitempty"` // Unhealthy Threshold field
-	Timestamp time.Time `firestore:"timestamp,omitempty"`      // Time at which State Field is updated
+	State     string    `firestore:"state,omitempty" json:"state"`                   // Field is either healthy or unhealthy
+	Uptime    int       `firestore:"uptime_count,omitempty" json:"uptime_count"`     // Healthy Threshold field
+	Downtime  int       `firestore:"downtime_count,omitempty" json:"downtime_count"` // Unhealthy Threshold field
+	Timestamp time.Time `firestore:"timestamp,omitempty" json:"timestamp"`           // Time at which State Field is updated
+}
+
+// Health states of a target.
+const (
+	StateHealthy   = "healthy"
+	StateUnhealthy = "unhealthy"
+)
+
+// Transition records a target changing state. It is emitted by the health
+// state machine when a threshold is reached.
+type Transition struct {
+	Target string    // Name of the target
+	From   string    // State before the transition
+	To     string    // State after the transition
+	At     time.Time // Time of the transition
+	Cause  Result    // The probe result that reached the threshold
+}
+
+// Incident is an outage of a target: it opens when the target turns unhealthy
+// and closes when it is healthy again.
+type Incident struct {
+	ID       string        `firestore:"id" json:"id"`
+	Target   string        `firestore:"target" json:"target"`
+	Opened   time.Time     `firestore:"opened" json:"opened"`     // Start of the first failed probe
+	Closed   time.Time     `firestore:"closed" json:"closed"`     // Time of recovery. Zero while the incident is open
+	Duration time.Duration `firestore:"duration" json:"duration"` // Closed - Opened. Zero while the incident is open
+	Reason   ErrorClass    `firestore:"reason" json:"reason"`     // Error class of the first failed probe
+	Error    string        `firestore:"error" json:"error"`       // Details of the first failure
+	Probes   []Result      `firestore:"probes" json:"probes"`     // The failed probes that opened the incident
+	Total    int           `firestore:"total" json:"total"`       // Probes run while the incident was open. Counted on close
+	Failures int           `firestore:"failures" json:"failures"` // Probes that failed while the incident was open
+}
+
+// IsOpen reports whether the target has not recovered yet.
+func (i Incident) IsOpen() bool { return i.Closed.IsZero() }
+
+// IncidentStats summarizes the incidents of a target.
+type IncidentStats struct {
+	Count int           `json:"count"` // Incidents the stats are computed from
+	MTTR  time.Duration `json:"mttr"`  // Mean time to recovery of the closed incidents
+	MTBF  time.Duration `json:"mtbf"`  // Mean time between failures: from a recovery to the next incident
+}
+
+// Lease grants its holder the right to run the probe scheduler until Expires.
+// Token grows every time the lease changes hands. It is the fencing token of
+// the term: stores keeping the lease reject the writes of a leader elected
+// with an older token (see core.Fence).
+type Lease struct {
+	Holder  string    `firestore:"holder" json:"holder"`   // Instance holding the lease
+	Token   int64     `firestore:"token" json:"token"`     // Term of the holder
+	Expires time.Time `firestore:"expires" json:"expires"` // Lease is free after this time
 }
 
 // Notification reads data from Cloud Firestore "config" collection
@@ -37,38 +132,157 @@ type Status struct {
 // we should stop/start getting email notification. This is on for the purpose fo this demo
 // since in real life we want to always get notification for up/downtime of a server
 type Notification struct {
-	Email  string `firestore:"email,omitempty"`  // Email to receive server status messages
-	Update bool   `firestore:"update,omitempty"` // Stop/Start receiving notification
-}
-
-// General Logs struct is a collection of log output to display in the web frontend
-// Each server holds a collection of GLogs to keep tract of important metrics.
-// For the purpose of this demonstration, Logs are persisted in memory. In real life we
-// will persist logs in nonvolatile memory like database or hardrive.
-type GLogs struct {
-	Auth       string // Log for auth token success of failure
-	Sent       string // Log for the message sent to the server
-	Received   string // Log message echoed from server
-	State      string // Log to indicate server status on each run. Accumulates for Threshold field.
-	Threshold  string // Log for success/failure threshold
-	CloudState bool   // Single bool. used in html template to know how to properly display element.
-	Email      string // Fed to Notification Struct Email Field. Only available for http server
-	Update     bool   // Fed to Nofification Update Field.
-}
-
-// A collection of all our logs and data to display in web frontend for the Tcp Echo Server
-type TcpLogWarehouse struct {
-	ClientLogs GLogs
+	Email  string `firestore:"email,omitempty" json:"email"`   // Email to receive server status messages
+	Update bool   `firestore:"update,omitempty" json:"update"` // Stop/Start receiving notification
+}
+
+// LogEntry is a single entry in a target's log feed in the web frontend: the
+// result of a probe and the threshold message of the health check, if any.
+// The terminal style text is rendered from these fields by the html template.
+type LogEntry struct {
+	Seq uint64 // Position in the target's log. Used to page back through older entries
+	Result
+	Threshold string // Message for success/failure threshold
+}
+
+// Event is pushed to the browsers connected to the live feed.
+type Event struct {
+	Type   string    `json:"type"`             // "result", "status" or "transition"
+	Target string    `json:"target"`           // Name of the target
+	Seq    uint64    `json:"seq,omitempty"`    // Log sequence number of a result
+	Result *ResultV1 `json:"result,omitempty"` // The probe result of a result event
+	HTML   string    `json:"html,omitempty"`   // Feed lines of a result event, as rendered by home.html
+	Status *StatusV1 `json:"status,omitempty"` // The new status of a status or transition event
+	From   string    `json:"from,omitempty"`   // State before a transition
+	To     string    `json:"to,omitempty"`     // State after a transition
+}
+
+// A collection of all our logs and data to display in web frontend for a single target.
+type TargetLogWarehouse struct {
+	Name       string
+	Type       string
+	Address    string
+	ClientLogs Result
 	StatusLogs Status
-	LogSlice   []GLogs
+	LogSlice   []LogEntry
+	SLO        *SLOReport // Latest SLO report. Nil when the target has no objective
+	Incidents  []Incident // Most recent incidents, newest first
+	Stats      IncidentStats
 }
 
-// Global Log Warehouse that. Contains all logs and data for both tcp and http.
+// Global Log Warehouse that. Contains all logs and data for every target.
 // This struct is passed to our template.
 type LogWarehouse struct {
-	Notification    Notification
-	ClientLogs      GLogs
-	StatusLogs      Status
-	LogSlice        []GLogs
-	TcpLogWarehouse TcpLogWarehouse
+	Notification Notification
+	Targets      []TargetLogWarehouse
+}
+
+// Target describes a single endpoint to monitor. Type selects the Prober
+// registered in core that knows how to make contact with it. Zero valued fields
+// fall back to the matching field in env_variables.
+type Target struct {
+	Name        string `yaml:"name"`                // Unique name. Also used as the document id in "current_status"
+	Type        string `yaml:"type"`                // Registered probe type, e.g. "http" or "tcp"
+	Address     string `yaml:"address"`             // Base url for http, host:port for tcp
+	Token       string `yaml:"token"`               // authentication token
+	TokenFile   string `yaml:"token_file"`          // file holding the authentication token, instead of token
+	Message     string `yaml:"message"`             // the message to send to the echo server
+	Timeout     int    `yaml:"timeout"`             // timeout in seconds
+	Interval    int    `yaml:"interval"`            // how long to pause between probes in seconds
+	HThreshold  int    `yaml:"healthy_threshold"`   // healthy threshold
+	UhThreshold int    `yaml:"unhealthy_threshold"` // unhealthy threshold
+	SLO         SLO    `yaml:"slo"`                 // availability objective. Disabled when the objective is zero
+}
+
+// SLO is the availability objective of a target, e.g. 99.9% of probes
+// succeed over 30 days.
+type SLO struct {
+	Objective  float64 `yaml:"objective" json:"objective"`     // Percentage of probes that must succeed
+	WindowDays int     `yaml:"window_days" json:"window_days"` // Rolling window the objective applies to
+}
+
+// Enabled reports whether an objective is set.
+func (s SLO) Enabled() bool { return s.Objective > 0 }
+
+// SLOWindow is the availability of a target over a rolling window.
+type SLOWindow struct {
+	Window       time.Duration `json:"window"`
+	Total        int           `json:"total"`        // Probes run in the window
+	Failures     int           `json:"failures"`     // Probes that failed in the window
+	Availability float64       `json:"availability"` // Percentage of successful probes. 100 without probes
 }
+
+// BurnRate is how fast a target spends its error budget over a long and a
+// short window. A burn rate of 1 spends exactly the whole budget over the
+// SLO window. The alert fires when both windows burn faster than Threshold.
+type BurnRate struct {
+	Alert     string        `json:"alert"` // Name of the alert, e.g. "fast" or "slow"
+	Long      time.Duration `json:"long"`
+	Short     time.Duration `json:"short"`
+	Threshold float64       `json:"threshold"`
+	LongRate  float64       `json:"long_rate"`
+	ShortRate float64       `json:"short_rate"`
+	Firing    bool          `json:"firing"`
+}
+
+// SLOReport is the availability of a target against its objective.
+type SLOReport struct {
+	Target          string      `json:"target"`
+	SLO             SLO         `json:"slo"`
+	At              time.Time   `json:"at"`               // Time the report was computed
+	Windows         []SLOWindow `json:"windows"`          // Availability over the last 1, 7 and 30 days
+	Budget          SLOWindow   `json:"budget"`           // Availability over the SLO window
+	BudgetRemaining float64     `json:"budget_remaining"` // Fraction of the error budget left. Negative once overspent
+	Burn            []BurnRate  `json:"burn"`
+}
+
+// Result is returned by a Prober for a single run against a Target.
+// Every Result is persisted to the probe history of the store.
+type Result struct {
+	Target     string        `firestore:"target" json:"target"`           // Name of the probed target
+	Type       string        `firestore:"type" json:"type"`               // Probe type of the target
+	Start      time.Time     `firestore:"start" json:"start"`             // Time at which the probe started
+	Duration   time.Duration `firestore:"duration" json:"duration"`       // How long the probe took
+	Auth       bool          `firestore:"auth" json:"auth"`               // Whether the auth token was accepted
+	Sent       string        `firestore:"sent" json:"sent"`               // Payload sent to the server
+	Received   string        `firestore:"received" json:"received"`       // Payload echoed back by the server
+	Success    bool          `firestore:"success" json:"success"`         // Whether the expected echo was received
+	ErrorClass ErrorClass    `firestore:"error_class" json:"error_class"` // Why the probe failed. Empty on success
+	Error      string        `firestore:"error" json:"error"`             // Details of the failure, if any
+	TraceID    string        `firestore:"trace_id" json:"trace_id"`       // Trace of the probe, if it was traced
+	SpanID     string        `firestore:"span_id" json:"span_id"`         // Span of the probe within the trace
+}
+
+// Rollup aggregates the results of a target over a minute or an hour. Raw
+// results are pruned after a while, rollups are kept for much longer.
+type Rollup struct {
+	Target     string        `firestore:"target" json:"target"`
+	Resolution time.Duration `firestore:"resolution" json:"resolution"` // time.Minute or time.Hour
+	Start      time.Time     `firestore:"start" json:"start"`           // Start of the minute or hour
+	Count      int           `firestore:"count" json:"count"`           // Probes started in the period
+	Failures   int           `firestore:"failures" json:"failures"`     // Probes that failed
+	Min        time.Duration `firestore:"min" json:"min"`               // Latency of the probes
+	Avg        time.Duration `firestore:"avg" json:"avg"`
+	P95        time.Duration `firestore:"p95" json:"p95"`
+	Max        time.Duration `firestore:"max" json:"max"`
+}
+
+// ErrorClass classifies why a probe failed. Probers set it on the Result
+// instead of logging the error, so the cause of a failure is kept with it in
+// the probe history, incidents and metrics.
+type ErrorClass string
+
+// Error classes. Every failed probe is classified into exactly one of these so
+// that "the echo server is wrong" can be told apart from "the network is down".
+const (
+	ErrNone           ErrorClass = ""                       // The probe succeeded
+	ErrDNS            ErrorClass = "dns_failure"            // The target host name could not be resolved
+	ErrRefused        ErrorClass = "connection_refused"     // The target actively refused the connection
+	ErrConnectTimeout ErrorClass = "connect_timeout"        // No connection could be established in time
+	ErrTLS            ErrorClass = "tls_error"              // The TLS handshake or certificate verification failed
+	ErrAuth           ErrorClass = "auth_rejected"          // The server did not accept the auth token
+	ErrReadTimeout    ErrorClass = "read_timeout"           // Connected, but the server did not answer in time
+	ErrEchoMismatch   ErrorClass = "echo_mismatch"          // The server answered with something other than the echo
+	ErrHttpStatus     ErrorClass = "unexpected_http_status" // The http server answered with a non 200 status code
+	ErrUnknown        ErrorClass = "unknown"                // The probe failed for any other reason
+)
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/icommit/SRETest/core"
+	"github.com/icommit/SRETest/pkg/models"
+)
+
+var confMu sync.RWMutex // Guards conf, which is replaced on reload
+var reloadMu sync.Mutex // One reload at a time
+
+// config returns the configuration in use.
+func config() *models.Config {
+	confMu.RLock()
+	defer confMu.RUnlock()
+	return conf
+}
+
+func setConfig(c *models.Config) {
+	confMu.Lock()
+	defer confMu.Unlock()
+	conf = c
+	core.SetMail(c)
+}
+
+// loadConfig reads the configuration file at path with, in increasing order of
+// precedence, the settings of the environment and the command line on top.
+func loadConfig(path string) (*models.Config, error) {
+	return core.Load(path, core.EnvLayer(os.Environ()), flagLayer)
+}
+
+// watchConfig reloads the configuration whenever its file changes or the
+// process gets SIGHUP, until ctx is done.
+func watchConfig(ctx context.Context) {
+	if err := core.WatchConfig(ctx, configFile(), func() { reload(ctx) }); err != nil {
+		log.Printf("config: not watching %s, send SIGHUP to reload: %v", configFile(), err)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reload(ctx)
+			}
+		}
+	}()
+}
+
+// reload reads the configuration file again and applies what changed. A file
+// that cannot be read or is invalid is logged and the configuration in use
+// kept, so a half written file never stops the monitor.
+func reload(ctx context.Context) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	path := configFile()
+	C, err := loadConfig(path)
+	if err != nil {
+		log.Printf("config: keeping previous configuration: %v", err)
+		return
+	}
+	diff := core.DiffConfig(config(), C)
+	if diff.Empty() {
+		log.Printf("config: %s reloaded, nothing changed", path)
+		return
+	}
+	log.Printf("config: %s reloaded:\n%s", path, diff)
+	setConfig(C)
+	applyTargets(ctx, diff, core.Targets(C))
+}
+
+// Helper function that brings the frontend and, on the leader, the probe loops
+// in line with the targets of a new configuration.
+func applyTargets(ctx context.Context, diff core.ConfigDiff, targets []models.Target) {
+	for _, target := range diff.Removed {
+		results.Remove(target.Name)
+		core.DefaultMetrics.Forget(target.Name)
+	}
+	for _, target := range diff.Added {
+		if err := db.EnsureStatus(ctx, target.Name); err != nil {
+			log.Printf("%s: failed to create status: %v", target.Name, err)
+		}
+	}
+	for _, target := range targets {
+		results.Add(target)
+	}
+	if l := currentLoops(); l != nil {
+		l.apply(results.Targets())
+	}
+	for _, target := range diff.Added {
+		refreshStatus(ctx, target.Name)
+	}
+}